@@ -27,9 +27,14 @@ type Expression interface {
 	PosFrom() Pos
 }
 
+// MyStatement declares Name, or every name bound by Pattern when the
+// declaration destructures its value. A Const declaration, written with
+// const, cannot be assigned to afterwards.
 type MyStatement struct {
-	Name  string
-	Value *Expression
+	Name    string
+	Pattern Pattern
+	Value   *Expression
+	Const   bool
 	Pos
 }
 
@@ -37,10 +42,14 @@ func (s MyStatement) PosFrom() Pos {
 	return s.Pos
 }
 
+// SubStatement defines a sub. A sub whose body contains yield is a
+// Generator: calling it returns an iterator that runs the body lazily.
 type SubStatement struct {
-	Name   string
-	Params []string
-	Body   []Statement
+	Name      string
+	Params    []Param
+	Body      []Statement
+	Doc       string
+	Generator bool
 	Pos
 }
 
@@ -48,6 +57,29 @@ func (s SubStatement) PosFrom() Pos {
 	return s.Pos
 }
 
+// Param is a parameter of a sub. A parameter with a Default may be left out
+// of a call, and a Rest parameter, which comes last, takes the remaining
+// arguments as an array.
+type Param struct {
+	Name    string
+	Default Expression
+	Rest    bool
+}
+
+// ClassStatement defines a class, optionally extending Parent, which must
+// evaluate to another class.
+type ClassStatement struct {
+	Name    string
+	Parent  Expression
+	Methods []*SubStatement
+	Doc     string
+	Pos
+}
+
+func (s ClassStatement) PosFrom() Pos {
+	return s.Pos
+}
+
 type ElseIf struct {
 	Condition Expression
 	Then      []Statement
@@ -119,6 +151,7 @@ func (s DoUntilStatement) PosFrom() Pos {
 
 type ForStatement struct {
 	Name       string
+	Pattern    Pattern
 	Expression Expression
 	Body       []Statement
 	Pos
@@ -128,6 +161,83 @@ func (s ForStatement) PosFrom() Pos {
 	return s.Pos
 }
 
+// Pattern is the left-hand side of a destructuring declaration or for loop,
+// or the pattern of a case in a WhenMatchStatement.
+type Pattern interface {
+	PosFrom() Pos
+}
+
+// NamePattern binds the whole value to Name. The name _ matches anything
+// without binding it.
+type NamePattern struct {
+	Name string
+	Pos
+}
+
+func (s NamePattern) PosFrom() Pos {
+	return s.Pos
+}
+
+// ArrayPattern matches an array with one element per pattern in Elements.
+// With a Rest pattern the array may be longer, and Rest is matched against
+// an array of the remaining elements.
+type ArrayPattern struct {
+	Elements []Pattern
+	Rest     Pattern
+	Pos
+}
+
+func (s ArrayPattern) PosFrom() Pos {
+	return s.Pos
+}
+
+// HashPattern matches a hash that has every key in Keys, matching the
+// value of Keys[n] against Values[n].
+type HashPattern struct {
+	Keys   []string
+	Values []Pattern
+	Pos
+}
+
+func (s HashPattern) PosFrom() Pos {
+	return s.Pos
+}
+
+// LiteralPattern matches values equal to Value.
+type LiteralPattern struct {
+	Value Expression
+	Pos
+}
+
+func (s LiteralPattern) PosFrom() Pos {
+	return s.Pos
+}
+
+// RangePattern matches numbers or strings from Low up to High, including
+// High unless Exclusive is set.
+type RangePattern struct {
+	Low       Expression
+	High      Expression
+	Exclusive bool
+	Pos
+}
+
+func (s RangePattern) PosFrom() Pos {
+	return s.Pos
+}
+
+// TypePattern matches values of the named type, such as str or num, and
+// binds them to Name if it is set.
+type TypePattern struct {
+	Type string
+	Name string
+	Pos
+}
+
+func (s TypePattern) PosFrom() Pos {
+	return s.Pos
+}
+
 type Branch struct {
 	Condition Expression
 	Then      []Statement
@@ -143,13 +253,23 @@ func (s WhenStatement) PosFrom() Pos {
 	return s.Pos
 }
 
+// WhenMatchStatement runs the first case whose pattern matches Value. Else_
+// is nil when there is no else block, and empty when the block is.
 type WhenMatchStatement struct {
 	Value Expression
-	Cases []Branch
+	Cases []MatchBranch
 	Else_ []Statement
 	Pos
 }
 
+// MatchBranch is a case of a WhenMatchStatement. Guard, if set, is evaluated
+// after the pattern's names are bound and must be true for the case to run.
+type MatchBranch struct {
+	Pattern Pattern
+	Guard   Expression
+	Then    []Statement
+}
+
 func (s WhenMatchStatement) PosFrom() Pos {
 	return s.Pos
 }
@@ -164,6 +284,17 @@ func (s CallStatement) PosFrom() Pos {
 	return s.Pos
 }
 
+// YieldStatement hands Value to the loop iterating over the generator it
+// runs in, and pauses the generator until the next value is wanted.
+type YieldStatement struct {
+	Value Expression
+	Pos
+}
+
+func (s YieldStatement) PosFrom() Pos {
+	return s.Pos
+}
+
 type ReturnStatement struct {
 	Value *Expression
 	Pos
@@ -173,9 +304,13 @@ func (s ReturnStatement) PosFrom() Pos {
 	return s.Pos
 }
 
+// AssignmentStatement assigns Value to Left, which is a Variable, Index or
+// Member. Operator is Assign, or one of the compound forms such as
+// PlusAssign, which combine the old value with Value.
 type AssignmentStatement struct {
-	Left  Expression
-	Value Expression
+	Left     Expression
+	Operator TokenType
+	Value    Expression
 	Pos
 }
 
@@ -221,6 +356,52 @@ func (s StringLiteral) PosFrom() Pos {
 	return s.Pos
 }
 
+// BranchExpression is an if, unless or when used as an expression. Its
+// value is that of the last ValueStatement run in the chosen branch, or nil
+// if there is none.
+type BranchExpression struct {
+	Statement Statement
+	Pos
+}
+
+func (s BranchExpression) PosFrom() Pos {
+	return s.Pos
+}
+
+// ValueStatement is an expression in a branch of a BranchExpression. It is
+// evaluated, and its value kept as the value of the branch.
+type ValueStatement struct {
+	Value Expression
+	Pos
+}
+
+func (s ValueStatement) PosFrom() Pos {
+	return s.Pos
+}
+
+// BytesLiteral is a b"..." literal.
+type BytesLiteral struct {
+	Value []byte
+	Pos
+}
+
+func (s BytesLiteral) PosFrom() Pos {
+	return s.Pos
+}
+
+// Interpolation is a string with embedded expressions. Parts holds the
+// literal text as StringLiterals, interleaved with the expressions, and
+// Starts holds the position of the first token of each part.
+type Interpolation struct {
+	Parts  []Expression
+	Starts []Pos
+	Pos
+}
+
+func (s Interpolation) PosFrom() Pos {
+	return s.Pos
+}
+
 type BooleanLiteral struct {
 	Value bool
 	Pos
@@ -247,11 +428,27 @@ func (s ArrayLiteral) PosFrom() Pos {
 	return s.Pos
 }
 
+// SetLiteral is a %[...] literal.
+type SetLiteral struct {
+	Values []Expression
+	Pos
+}
+
+func (s SetLiteral) PosFrom() Pos {
+	return s.Pos
+}
+
 type HashLiteral struct {
-	Pairs map[Expression]Expression
+	Pairs []Pair
 	Pos
 }
 
+// Pair is a key and its value in a hash literal.
+type Pair struct {
+	Key   Expression
+	Value Expression
+}
+
 func (s HashLiteral) PosFrom() Pos {
 	return s.Pos
 }
@@ -265,9 +462,13 @@ func (s Variable) PosFrom() Pos {
 	return s.Pos
 }
 
+// Index is left[index], or left?[index], which is Optional: it gives nil
+// instead of failing when left is nil or has nothing at index. When left is
+// nil, the rest of the chain it is in is skipped and gives nil too.
 type Index struct {
-	Left  Expression
-	Index Expression
+	Left     Expression
+	Index    Expression
+	Optional bool
 	Pos
 }
 
@@ -275,9 +476,14 @@ func (s Index) PosFrom() Pos {
 	return s.Pos
 }
 
+// Member is left.member, or left?.member, which is Optional: it gives nil
+// instead of failing when left is nil or has no such member. When left is
+// nil, the rest of the chain it is in is skipped, so a?.b.c is nil when a
+// is.
 type Member struct {
-	Left   Expression
-	Member string
+	Left     Expression
+	Member   string
+	Optional bool
 	Pos
 }
 
@@ -295,6 +501,41 @@ func (s Call) PosFrom() Pos {
 	return s.Pos
 }
 
+// SpawnExpression is spawn f(args), which calls f in a new task. It can be
+// used as an expression, giving the task's handle, or as a statement.
+type SpawnExpression struct {
+	Call *Call
+	Pos
+}
+
+func (s SpawnExpression) PosFrom() Pos {
+	return s.Pos
+}
+
+// Spread is ...value in the arguments of a call. The elements of an array,
+// set, range or iterator become arguments, and the entries of a hash become
+// named arguments.
+type Spread struct {
+	Value Expression
+	Pos
+}
+
+func (s Spread) PosFrom() Pos {
+	return s.Pos
+}
+
+// NamedArgument is name: value in the arguments of a call, which passes
+// value to the parameter called name.
+type NamedArgument struct {
+	Name  string
+	Value Expression
+	Pos
+}
+
+func (s NamedArgument) PosFrom() Pos {
+	return s.Pos
+}
+
 type Unary struct {
 	Operator TokenType
 	Right    Expression
@@ -316,6 +557,20 @@ func (s Binary) PosFrom() Pos {
 	return s.Pos
 }
 
+// RangeExpression is start..end or start..<end, with an optional step
+// given with by. Step is nil when it isn't given.
+type RangeExpression struct {
+	Start     Expression
+	End       Expression
+	Step      Expression
+	Exclusive bool
+	Pos
+}
+
+func (s RangeExpression) PosFrom() Pos {
+	return s.Pos
+}
+
 type BlockExpression struct {
 	Body Expression
 	Pos
@@ -326,7 +581,7 @@ func (s BlockExpression) PosFrom() Pos {
 }
 
 type FunctionLiteral struct {
-	Params []string
+	Params []Param
 	Body   []Statement
 	Pos
 }
@@ -335,6 +590,19 @@ func (s FunctionLiteral) PosFrom() Pos {
 	return s.Pos
 }
 
+// Assignment is an assignment used as an expression. It evaluates to the
+// assigned value.
+type Assignment struct {
+	Left     Expression
+	Operator TokenType
+	Value    Expression
+	Pos
+}
+
+func (s Assignment) PosFrom() Pos {
+	return s.Pos
+}
+
 type Increment struct {
 	Left Expression
 	By   Expression
@@ -352,3 +620,217 @@ type Decrement struct {
 func (s Decrement) PosFrom() Pos {
 	return s.Left.PosFrom()
 }
+
+// Node is implemented by every statement and expression.
+type Node interface {
+	PosFrom() Pos
+}
+
+// Blocks returns the statement lists nested directly inside node, in source
+// order. Conditionals always include their else block, even when it is nil.
+func Blocks(node Node) [][]Statement {
+	switch node := node.(type) {
+	case *SubStatement:
+		return [][]Statement{node.Body}
+	case *IfStatement:
+		var blocks = [][]Statement{node.Then}
+		for _, elseIf := range node.ElseIfs {
+			blocks = append(blocks, elseIf.Then)
+		}
+		return append(blocks, node.Else_)
+	case *UnlessStatement:
+		var blocks = [][]Statement{node.Then}
+		for _, elseIf := range node.ElseIfs {
+			blocks = append(blocks, elseIf.Then)
+		}
+		return append(blocks, node.Else_)
+	case *WhileStatement:
+		return [][]Statement{node.Body}
+	case *DoWhileStatement:
+		return [][]Statement{node.Body}
+	case *UntilStatement:
+		return [][]Statement{node.Body}
+	case *DoUntilStatement:
+		return [][]Statement{node.Body}
+	case *ForStatement:
+		return [][]Statement{node.Body}
+	case *WhenStatement:
+		var blocks [][]Statement
+		for _, branch := range node.Cases {
+			blocks = append(blocks, branch.Then)
+		}
+		return append(blocks, node.Else_)
+	case *WhenMatchStatement:
+		var blocks [][]Statement
+		for _, branch := range node.Cases {
+			blocks = append(blocks, branch.Then)
+		}
+		return append(blocks, node.Else_)
+	case *FunctionLiteral:
+		return [][]Statement{node.Body}
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node in depth-first order, calling f
+// for every statement, expression and pattern. If f returns false the
+// children of that node are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	var inspectAll = func(nodes []Statement) {
+		for _, n := range nodes {
+			Inspect(n, f)
+		}
+	}
+	var inspectExprs = func(nodes []Expression) {
+		for _, n := range nodes {
+			Inspect(n, f)
+		}
+	}
+	var inspectParams = func(params []Param) {
+		for _, param := range params {
+			Inspect(param.Default, f)
+		}
+	}
+	switch node := node.(type) {
+	case *MyStatement:
+		Inspect(node.Pattern, f)
+		if node.Value != nil {
+			Inspect(*node.Value, f)
+		}
+	case *IfStatement:
+		Inspect(node.Conditions, f)
+		inspectAll(node.Then)
+		for _, elseIf := range node.ElseIfs {
+			Inspect(elseIf.Condition, f)
+			inspectAll(elseIf.Then)
+		}
+		inspectAll(node.Else_)
+	case *BranchExpression:
+		Inspect(node.Statement, f)
+	case *ValueStatement:
+		Inspect(node.Value, f)
+	case *UnlessStatement:
+		Inspect(node.Condition, f)
+		inspectAll(node.Then)
+		for _, elseIf := range node.ElseIfs {
+			Inspect(elseIf.Condition, f)
+			inspectAll(elseIf.Then)
+		}
+		inspectAll(node.Else_)
+	case *WhileStatement:
+		Inspect(node.Condition, f)
+		inspectAll(node.Body)
+	case *DoWhileStatement:
+		inspectAll(node.Body)
+		Inspect(node.Condition, f)
+	case *UntilStatement:
+		Inspect(node.Condition, f)
+		inspectAll(node.Body)
+	case *DoUntilStatement:
+		inspectAll(node.Body)
+		Inspect(node.Condition, f)
+	case *ForStatement:
+		Inspect(node.Pattern, f)
+		Inspect(node.Expression, f)
+		inspectAll(node.Body)
+	case *WhenStatement:
+		for _, branch := range node.Cases {
+			Inspect(branch.Condition, f)
+			inspectAll(branch.Then)
+		}
+		inspectAll(node.Else_)
+	case *WhenMatchStatement:
+		Inspect(node.Value, f)
+		for _, branch := range node.Cases {
+			Inspect(branch.Pattern, f)
+			Inspect(branch.Guard, f)
+			inspectAll(branch.Then)
+		}
+		inspectAll(node.Else_)
+	case *ArrayPattern:
+		for _, element := range node.Elements {
+			Inspect(element, f)
+		}
+		Inspect(node.Rest, f)
+	case *HashPattern:
+		for _, value := range node.Values {
+			Inspect(value, f)
+		}
+	case *LiteralPattern:
+		Inspect(node.Value, f)
+	case *RangePattern:
+		Inspect(node.Low, f)
+		Inspect(node.High, f)
+	case *CallStatement:
+		Inspect(node.Function, f)
+		inspectExprs(node.Args)
+	case *ReturnStatement:
+		if node.Value != nil {
+			Inspect(*node.Value, f)
+		}
+	case *YieldStatement:
+		Inspect(node.Value, f)
+	case *AssignmentStatement:
+		Inspect(node.Left, f)
+		Inspect(node.Value, f)
+	case *Assignment:
+		Inspect(node.Left, f)
+		Inspect(node.Value, f)
+	case *RangeExpression:
+		Inspect(node.Start, f)
+		Inspect(node.End, f)
+		Inspect(node.Step, f)
+	case *Interpolation:
+		inspectExprs(node.Parts)
+	case *ArrayLiteral:
+		inspectExprs(node.Values)
+	case *SetLiteral:
+		inspectExprs(node.Values)
+	case *HashLiteral:
+		for _, pair := range node.Pairs {
+			Inspect(pair.Key, f)
+			Inspect(pair.Value, f)
+		}
+	case *Index:
+		Inspect(node.Left, f)
+		Inspect(node.Index, f)
+	case *Member:
+		Inspect(node.Left, f)
+	case *Call:
+		Inspect(node.Function, f)
+		inspectExprs(node.Args)
+	case *SpawnExpression:
+		Inspect(node.Call, f)
+	case *Spread:
+		Inspect(node.Value, f)
+	case *NamedArgument:
+		Inspect(node.Value, f)
+	case *Unary:
+		Inspect(node.Right, f)
+	case *Binary:
+		Inspect(node.Left, f)
+		Inspect(node.Right, f)
+	case *BlockExpression:
+		Inspect(node.Body, f)
+	case *SubStatement:
+		inspectParams(node.Params)
+		inspectAll(node.Body)
+	case *ClassStatement:
+		Inspect(node.Parent, f)
+		for _, method := range node.Methods {
+			Inspect(method, f)
+		}
+	case *FunctionLiteral:
+		inspectParams(node.Params)
+		inspectAll(node.Body)
+	case *Increment:
+		Inspect(node.Left, f)
+		Inspect(node.By, f)
+	case *Decrement:
+		Inspect(node.Left, f)
+		Inspect(node.By, f)
+	}
+}
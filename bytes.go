@@ -0,0 +1,40 @@
+package main
+
+// Bytes values are Go byte slices, which is also what the library's file,
+// network and http functions produce. A b"..." literal or the bytes builtin
+// creates them; indexing and iterating give each byte as an int.
+
+// toBytes converts value to bytes: a string gives its UTF-8 encoding, and an
+// array must hold integers from 0 to 255.
+func toBytes(value interface{}) ([]byte, bool) {
+	switch value := value.(type) {
+	case []byte:
+		return value, true
+	case string:
+		return []byte(value), true
+	case []interface{}:
+		var result = make([]byte, len(value))
+		for j, element := range value {
+			var b, ok = integer(element)
+			if !ok || b < 0 || b > 255 {
+				return nil, false
+			}
+			result[j] = byte(b)
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// printable replaces the bytes among values with their text, so that print
+// shows bytes the way they are joined to a string.
+func printable(values []interface{}) []interface{} {
+	var result = make([]interface{}, len(values))
+	for j, value := range values {
+		if data, ok := value.([]byte); ok {
+			value = string(data)
+		}
+		result[j] = value
+	}
+	return result
+}
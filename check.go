@@ -0,0 +1,256 @@
+package main
+
+// Diagnostic is a problem found in a program without running it.
+type Diagnostic struct {
+	Pos
+	Message string
+}
+
+// Check looks for likely mistakes in program and returns them as warnings,
+// in source order.
+func Check(program []Statement) []Diagnostic {
+	var c = &checker{globals: map[string]string{}}
+	for name := range library {
+		c.globals[name] = "library binding"
+	}
+	for name := range taskLibrary {
+		c.globals[name] = "library binding"
+	}
+	for _, stmt := range program {
+		declare(c.globals, stmt)
+	}
+	for _, stmt := range program {
+		c.check(nil, stmt)
+	}
+	return c.diagnostics
+}
+
+// checker walks a program for Check. It resolves names the way the
+// interpreter does: code in a sub or block sees that scope's names and then
+// the top level's, and code at the top level sees only the top level's.
+// Each scope maps its names to what they are: a "variable", a "constant" or
+// a "library binding".
+type checker struct {
+	diagnostics []Diagnostic
+	globals     map[string]string
+}
+
+func (c *checker) report(pos Pos, message string) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{Pos: pos, Message: message})
+}
+
+// check walks node in the scope local, which is nil at the top level.
+func (c *checker) check(local map[string]string, node Node) {
+	Inspect(node, func(node Node) bool {
+		switch node := node.(type) {
+		case *SubStatement:
+			c.function(node.Params, node.Body, "self", "super")
+			return false
+		case *FunctionLiteral:
+			c.function(node.Params, node.Body)
+			return false
+		case *BlockExpression:
+			var scope = map[string]string{"it": "variable"}
+			declare(scope, node.Body)
+			c.check(scope, node.Body)
+			return false
+		case *AssignmentStatement:
+			c.assign(local, node.Left)
+		case *Assignment:
+			c.assign(local, node.Left)
+		case *Increment:
+			c.assign(local, node.Left)
+		case *Decrement:
+			c.assign(local, node.Left)
+		case *ForStatement:
+			if node.Pattern == nil {
+				c.rebind(local, node.Name, node.Pos)
+			} else {
+				c.rebindPattern(local, node.Pattern)
+			}
+		case *WhenMatchStatement:
+			if node.Else_ == nil && !exhaustive(node) {
+				c.report(node.Pos, "when without else can fall through")
+			}
+			c.check(local, node.Value)
+			for _, branch := range node.Cases {
+				c.branch(local, branch)
+			}
+			for _, stmt := range node.Else_ {
+				c.check(local, stmt)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+// function checks the parameter defaults and body of a sub in a scope of
+// its own, holding its parameters and the given implicit names.
+func (c *checker) function(params []Param, body []Statement, implicit ...string) {
+	var scope = map[string]string{}
+	for _, name := range implicit {
+		scope[name] = "variable"
+	}
+	for _, param := range params {
+		scope[param.Name] = "variable"
+	}
+	for _, stmt := range body {
+		declare(scope, stmt)
+	}
+	for _, param := range params {
+		c.check(scope, param.Default)
+	}
+	for _, stmt := range body {
+		c.check(scope, stmt)
+	}
+}
+
+// branch checks a case of when in a scope of its own, holding the names
+// its pattern binds and declares, on top of the scope the when is in.
+func (c *checker) branch(local map[string]string, branch MatchBranch) {
+	var scope = map[string]string{}
+	for _, stmt := range branch.Then {
+		declare(scope, stmt)
+	}
+	Inspect(branch.Pattern, func(node Node) bool {
+		if name, ok := node.(*NamePattern); ok && name.Name != "_" {
+			if _, found := scope[name.Name]; !found {
+				scope[name.Name] = "variable"
+			}
+		}
+		return true
+	})
+	for name, kind := range local {
+		if _, found := scope[name]; !found {
+			scope[name] = kind
+		}
+	}
+	c.check(scope, branch.Guard)
+	for _, stmt := range branch.Then {
+		c.check(scope, stmt)
+	}
+}
+
+// lookup returns what name is in the scope local, or in the top level if
+// local doesn't have it, or "" if neither has it.
+func (c *checker) lookup(local map[string]string, name string) string {
+	if kind, found := local[name]; found {
+		return kind
+	}
+	return c.globals[name]
+}
+
+// assign reports an assignment to a constant or library binding, or into
+// a library binding's namespace, such as math.sqrt = nil. A member or index
+// target is followed down to the variable it starts from.
+func (c *checker) assign(local map[string]string, target Expression) {
+	var root = target
+	for {
+		if member, ok := root.(*Member); ok {
+			root = member.Left
+		} else if index, ok := root.(*Index); ok {
+			root = index.Left
+		} else {
+			break
+		}
+	}
+	var variable, ok = root.(*Variable)
+	if !ok {
+		return
+	}
+	var kind = c.lookup(local, variable.Name)
+	if root != target && kind == "constant" {
+		// the contents of a constant's value can change
+		return
+	}
+	if kind == "constant" || kind == "library binding" {
+		c.report(variable.Pos, "cannot assign to "+kind+" "+variable.Name)
+	}
+}
+
+// rebind reports a for loop pattern that binds the name of a constant or
+// library binding in the scope it runs in.
+func (c *checker) rebind(local map[string]string, name string, pos Pos) {
+	var scope = local
+	if scope == nil {
+		scope = c.globals
+	}
+	if kind := scope[name]; kind == "constant" || kind == "library binding" {
+		c.report(pos, "cannot assign to "+kind+" "+name)
+	}
+}
+
+func (c *checker) rebindPattern(local map[string]string, pattern Pattern) {
+	Inspect(pattern, func(node Node) bool {
+		if name, ok := node.(*NamePattern); ok && name.Name != "_" {
+			c.rebind(local, name.Name, name.Pos)
+		}
+		return true
+	})
+}
+
+// declare adds the names that node declares to scope, without looking into
+// subs, blocks and the cases of when, which have scopes of their own. A
+// constant stays one even if the name is declared again, which fails when
+// the program runs, but a declaration replaces a library binding.
+func declare(scope map[string]string, node Node) {
+	var add = func(name string, kind string) {
+		if old := scope[name]; old == "" || old == "library binding" || kind == "constant" && old == "variable" {
+			scope[name] = kind
+		}
+	}
+	var addPattern = func(pattern Pattern, kind string) {
+		Inspect(pattern, func(node Node) bool {
+			if name, ok := node.(*NamePattern); ok && name.Name != "_" {
+				add(name.Name, kind)
+			}
+			return true
+		})
+	}
+	Inspect(node, func(node Node) bool {
+		switch node := node.(type) {
+		case *MyStatement:
+			var kind = "variable"
+			if node.Const {
+				kind = "constant"
+			}
+			if node.Pattern == nil {
+				add(node.Name, kind)
+			} else {
+				addPattern(node.Pattern, kind)
+			}
+		case *ForStatement:
+			if node.Pattern == nil {
+				add(node.Name, "variable")
+			} else {
+				addPattern(node.Pattern, "variable")
+			}
+		case *WhenMatchStatement:
+			for _, stmt := range node.Else_ {
+				declare(scope, stmt)
+			}
+			return false
+		case *SubStatement:
+			add(node.Name, "variable")
+			return false
+		case *ClassStatement:
+			add(node.Name, "variable")
+			return false
+		case *FunctionLiteral, *BlockExpression:
+			return false
+		}
+		return true
+	})
+}
+
+// exhaustive reports whether some case of when matches every value: a
+// name pattern without a guard.
+func exhaustive(when *WhenMatchStatement) bool {
+	for _, branch := range when.Cases {
+		if _, ok := branch.Pattern.(*NamePattern); ok && branch.Guard == nil {
+			return true
+		}
+	}
+	return false
+}
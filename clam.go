@@ -2,6 +2,9 @@ package main
 
 import (
 	_ "embed"
+	"flag"
+	"fmt"
+	"os"
 )
 
 // embed file as string
@@ -10,21 +13,95 @@ import (
 var code string
 
 func main() {
-	var lexer = NewLexer(code)
-	var parser = NewParser(lexer)
-	var program []Statement
-	for {
-		program = append(program, parser.stmt())
-		if parser.next() == Eof {
-			break
-		}
+	if len(os.Args) < 2 {
+		prepare(Parse(code)).Run()
+		return
+	}
+	switch os.Args[1] {
+	case "run":
+		runCommand(os.Args[2:])
+	case "test":
+		testCommand(os.Args[2:])
+	case "doc":
+		docCommand(os.Args[2:])
+	default:
+		usage()
 	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: clam run [-cover] file.clm")
+	fmt.Fprintln(os.Stderr, "       clam test [-v] [-run regexp] [-timeout d] [-junit file] [-cover] [path ...]")
+	fmt.Fprintln(os.Stderr, "       clam doc [-format markdown|html] [-o file] [file.clm ...]")
+	os.Exit(2)
+}
+
+// prepare creates an interpreter for program with the standard library in
+// its global scope.
+func prepare(program []Statement) *Interpreter {
 	var interpreter = NewInterpreter(program)
 	for k, v := range library {
-		interpreter.Variables[0][k] = v
+		// each interpreter gets its own namespaces, which are read-only, so
+		// that no program can change them under another
+		if namespace, ok := v.(*Hash); ok {
+			namespace = namespace.Copy()
+			namespace.Freeze()
+			v = namespace
+		}
+		interpreter.Variables[0][k] = constant{value: v, library: true}
 	}
-	for _, doc := range docs {
-		println(doc)
+	for k, bind := range taskLibrary {
+		var v = bind(interpreter)
+		if namespace, ok := v.(*Hash); ok {
+			namespace.Freeze()
+		}
+		interpreter.Variables[0][k] = constant{value: v, library: true}
+	}
+	return interpreter
+}
+
+func runCommand(args []string) {
+	var flags = flag.NewFlagSet("run", flag.ExitOnError)
+	var cover = flags.Bool("cover", false, "record statement and branch coverage")
+	var coverProfile = flags.String("coverprofile", "coverage.out", "file to write the coverage profile to")
+	var coverHTML = flags.String("coverhtml", "coverage.html", "file to write the annotated coverage view to")
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		usage()
+	}
+	var path = flags.Arg(0)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var program []Statement
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, panicMessage(r))
+				os.Exit(1)
+			}
+		}()
+		program = Parse(string(source))
+	}()
+	printDiagnostics(path, Check(program))
+	var interpreter = prepare(program)
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", path, interpreter.Pos.Line+1, interpreter.Pos.Column+1, panicMessage(r))
+			os.Exit(1)
+		}
+	}()
+	if *cover {
+		interpreter.Coverage = NewCoverage(path, string(source), program)
+		defer writeCoverage([]*Coverage{interpreter.Coverage}, *coverProfile, *coverHTML)
 	}
 	interpreter.Run()
 }
+
+func printDiagnostics(path string, diagnostics []Diagnostic) {
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: warning: %s\n", path, diagnostic.Line+1, diagnostic.Column+1, diagnostic.Message)
+	}
+}
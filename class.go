@@ -0,0 +1,102 @@
+package main
+
+// ClassValue is a user-defined type, the value of a class statement. Calling
+// a class creates an Object and passes the arguments to its init method.
+type ClassValue struct {
+	Name    string
+	Parent  *ClassValue
+	Methods map[string]*SubStatement
+
+	interpreter *Interpreter
+}
+
+// Object is an instance of a class. Its fields are set through self in the
+// methods, or with member assignment from outside.
+type Object struct {
+	Class  *ClassValue
+	Fields *Hash
+}
+
+// Super is the value of super in a method. Its members are the methods of
+// the parent of the class that defined the method, bound to the same self.
+type Super struct {
+	Class *ClassValue
+	Self  *Object
+}
+
+// method looks name up in c and its ancestors, and returns the method along
+// with the class that defines it.
+func (c *ClassValue) method(name string) (*SubStatement, *ClassValue, bool) {
+	for class := c; class != nil; class = class.Parent {
+		if method, ok := class.Methods[name]; ok {
+			return method, class, true
+		}
+	}
+	return nil, nil, false
+}
+
+// New creates an instance of c, running init if the class has one.
+func (c *ClassValue) New(args []interface{}) *Object {
+	var object = &Object{Class: c, Fields: NewHash()}
+	if init, owner, ok := c.method("init"); ok {
+		c.interpreter.sub(init, object, owner)(args...)
+	} else if len(args) > 0 {
+		panic(c.Name + " has no init method to take arguments")
+	}
+	return object
+}
+
+func (c *ClassValue) String() string {
+	return "class " + c.Name
+}
+
+// member returns the field called name, or else the method called name
+// bound to o.
+func (o *Object) member(name string) (interface{}, bool) {
+	if value, ok := o.Fields.Get(name); ok {
+		return value, true
+	}
+	if method, owner, ok := o.Class.method(name); ok {
+		return o.Class.interpreter.sub(method, o, owner), true
+	}
+	return nil, false
+}
+
+// hook calls the method name of o, which overloads an operator or other
+// built-in behaviour, if the class defines it.
+func (o *Object) hook(name string, args ...interface{}) (interface{}, bool) {
+	var method, owner, ok = o.Class.method(name)
+	if !ok {
+		return nil, false
+	}
+	return o.Class.interpreter.sub(method, o, owner)(args...), true
+}
+
+// operatorHooks names the method that overloads each arithmetic operator.
+var operatorHooks = map[TokenType]string{
+	Plus:     "__add",
+	Minus:    "__sub",
+	Multiply: "__mul",
+	Divide:   "__div",
+	Modulo:   "__mod",
+	Power:    "__pow",
+}
+
+// String uses the __str method if the class has one, so that objects print
+// and interpolate as they choose.
+func (o *Object) String() string {
+	if result, ok := o.hook("__str"); ok {
+		return stringify(result)
+	}
+	return o.Class.Name + repr(o.Fields)
+}
+
+func (s *Super) member(name string) (interface{}, bool) {
+	if s.Class == nil {
+		return nil, false
+	}
+	if method, owner, ok := s.Class.method(name); ok {
+		return s.Class.interpreter.sub(method, s.Self, owner), true
+	}
+	return nil, false
+}
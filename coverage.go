@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Coverage records how often each statement of a source file was executed,
+// and which branches of its if, unless and when statements were taken.
+type Coverage struct {
+	File       string
+	Source     string
+	Statements map[Statement]int
+	Branches   map[Statement][]int
+
+	// the tasks a program spawns run on goroutines of their own, so counts
+	// may be updated from several goroutines
+	mu sync.Mutex
+}
+
+// NewCoverage registers every statement and branch of program, so that code
+// which never runs is still reported with a count of zero.
+func NewCoverage(file string, source string, program []Statement) *Coverage {
+	var c = &Coverage{
+		File:       file,
+		Source:     source,
+		Statements: map[Statement]int{},
+		Branches:   map[Statement][]int{},
+	}
+	c.register(program)
+	for _, stmt := range program {
+		Inspect(stmt, func(node Node) bool {
+			var blocks = Blocks(node)
+			for _, block := range blocks {
+				c.register(block)
+			}
+			switch node.(type) {
+			case *IfStatement, *UnlessStatement, *WhenStatement, *WhenMatchStatement:
+				c.Branches[node] = make([]int, len(blocks))
+			}
+			return true
+		})
+	}
+	return c
+}
+
+func (c *Coverage) register(block []Statement) {
+	for _, stmt := range block {
+		c.Statements[stmt] = 0
+	}
+}
+
+func (c *Coverage) hit(stmt Statement) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Statements[stmt]++
+}
+
+// branch records that the n-th branch of stmt was taken. Branches are
+// numbered in source order, with the else branch last.
+func (c *Coverage) branch(stmt Statement, n int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counts, ok := c.Branches[stmt]; ok && n < len(counts) {
+		counts[n]++
+	}
+}
+
+// Percent returns the share of statements and branches that were executed.
+func (c *Coverage) Percent() (statements float64, branches float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var hit, total = 0, 0
+	for _, count := range c.Statements {
+		total++
+		if count > 0 {
+			hit++
+		}
+	}
+	statements = percent(hit, total)
+	hit, total = 0, 0
+	for _, counts := range c.Branches {
+		for _, count := range counts {
+			total++
+			if count > 0 {
+				hit++
+			}
+		}
+	}
+	return statements, percent(hit, total)
+}
+
+func percent(hit int, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(hit) * 100 / float64(total)
+}
+
+type coverBlock struct {
+	Pos
+	Stmts int
+	Count int
+}
+
+// blocks returns one block per statement followed by one block per branch,
+// sorted by position. Branch blocks carry no statements; they start at the
+// branch condition, or at the statement itself for the else branch.
+func (c *Coverage) blocks() []coverBlock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var blocks []coverBlock
+	for stmt, count := range c.Statements {
+		blocks = append(blocks, coverBlock{Pos: stmt.PosFrom(), Stmts: 1, Count: count})
+	}
+	for stmt, counts := range c.Branches {
+		var positions = branchPositions(stmt)
+		for j, count := range counts {
+			blocks = append(blocks, coverBlock{Pos: positions[j], Stmts: 0, Count: count})
+		}
+	}
+	sort.SliceStable(blocks, func(a, b int) bool {
+		if blocks[a].Line != blocks[b].Line {
+			return blocks[a].Line < blocks[b].Line
+		}
+		if blocks[a].Column != blocks[b].Column {
+			return blocks[a].Column < blocks[b].Column
+		}
+		return blocks[a].Stmts > blocks[b].Stmts
+	})
+	return blocks
+}
+
+func branchPositions(stmt Statement) []Pos {
+	var positions []Pos
+	switch stmt := stmt.(type) {
+	case *IfStatement:
+		positions = append(positions, stmt.Conditions.PosFrom())
+		for _, elseIf := range stmt.ElseIfs {
+			positions = append(positions, elseIf.Condition.PosFrom())
+		}
+	case *UnlessStatement:
+		positions = append(positions, stmt.Condition.PosFrom())
+		for _, elseIf := range stmt.ElseIfs {
+			positions = append(positions, elseIf.Condition.PosFrom())
+		}
+	case *WhenStatement:
+		for _, branch := range stmt.Cases {
+			positions = append(positions, branch.Condition.PosFrom())
+		}
+	case *WhenMatchStatement:
+		for _, branch := range stmt.Cases {
+			positions = append(positions, branch.Pattern.PosFrom())
+		}
+	}
+	return append(positions, stmt.PosFrom())
+}
+
+// WriteProfile writes the statement coverage of every file in the format of
+// Go's coverprofile. Lines and columns are 1-based. Each block starts at a
+// statement and extends up to the next statement on its line, or to the end
+// of the line, so that no two blocks overlap. Statements that start at the
+// same place, such as print(x) if x, share a block with the lowest of their
+// counts. Branches are not in the profile, which has no way to show them.
+func WriteProfile(w io.Writer, coverages []*Coverage) error {
+	if _, err := fmt.Fprintln(w, "mode: count"); err != nil {
+		return err
+	}
+	for _, c := range coverages {
+		var lines = strings.Split(c.Source, "\n")
+		var blocks []coverBlock
+		for _, block := range c.blocks() {
+			if block.Stmts == 0 {
+				continue
+			}
+			if n := len(blocks) - 1; n >= 0 && blocks[n].Pos == block.Pos {
+				blocks[n].Stmts++
+				if block.Count < blocks[n].Count {
+					blocks[n].Count = block.Count
+				}
+				continue
+			}
+			blocks = append(blocks, block)
+		}
+		for n, block := range blocks {
+			var end = block.Column + 1
+			if block.Line < len(lines) {
+				end = utf8.RuneCountInString(lines[block.Line]) + 1
+			}
+			if n+1 < len(blocks) && blocks[n+1].Line == block.Line {
+				end = blocks[n+1].Column + 1
+			}
+			_, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n",
+				c.File, block.Line+1, block.Column+1, block.Line+1, end, block.Stmts, block.Count)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type htmlLine struct {
+	Number int
+	Text   string
+	Class  string
+	Count  string
+	Note   string
+}
+
+type htmlFile struct {
+	Name       string
+	Statements string
+	Branches   string
+	Lines      []htmlLine
+}
+
+// WriteHTML writes an annotated view of every covered source file. Lines are
+// marked covered when all of their statements and branches were executed,
+// partial when only some were, and uncovered when none were.
+func WriteHTML(w io.Writer, coverages []*Coverage) error {
+	var files []htmlFile
+	for _, c := range coverages {
+		var statements, branches = c.Percent()
+		var file = htmlFile{
+			Name:       c.File,
+			Statements: fmt.Sprintf("%.1f%%", statements),
+			Branches:   fmt.Sprintf("%.1f%%", branches),
+		}
+		var hits = map[int][]int{}
+		var taken = map[int][]int{}
+		for _, block := range c.blocks() {
+			if block.Stmts > 0 {
+				hits[block.Line] = append(hits[block.Line], block.Count)
+			} else {
+				taken[block.Line] = append(taken[block.Line], block.Count)
+			}
+		}
+		for n, text := range strings.Split(c.Source, "\n") {
+			var line = htmlLine{Number: n + 1, Text: text}
+			var counts = append(append([]int{}, hits[n]...), taken[n]...)
+			if len(counts) > 0 {
+				var executed = 0
+				for _, count := range counts {
+					if count > 0 {
+						executed++
+					}
+				}
+				switch executed {
+				case len(counts):
+					line.Class = "cov"
+				case 0:
+					line.Class = "uncov"
+				default:
+					line.Class = "partial"
+				}
+			}
+			if len(hits[n]) > 0 {
+				var max = 0
+				for _, count := range hits[n] {
+					if count > max {
+						max = count
+					}
+				}
+				line.Count = fmt.Sprint(max)
+			}
+			if len(taken[n]) > 0 {
+				var executed = 0
+				for _, count := range taken[n] {
+					if count > 0 {
+						executed++
+					}
+				}
+				line.Note = fmt.Sprintf("%d/%d branches", executed, len(taken[n]))
+			}
+			file.Lines = append(file.Lines, line)
+		}
+		files = append(files, file)
+	}
+	return coverTemplate.Execute(w, files)
+}
+
+var coverTemplate = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>clam coverage</title>
+<style>
+body { font-family: sans-serif; background: #fff; color: #222; }
+table { border-collapse: collapse; font-family: monospace; font-size: 13px; }
+td { padding: 0 8px; white-space: pre; vertical-align: top; }
+td.n, td.c { color: #999; text-align: right; }
+td.b { color: #999; }
+tr.cov td.src { background: #d6f5d6; }
+tr.uncov td.src { background: #f8d0d0; }
+tr.partial td.src { background: #f8ecc0; }
+</style>
+</head>
+<body>
+{{range .}}
+<h2>{{.Name}}</h2>
+<p>{{.Statements}} of statements, {{.Branches}} of branches</p>
+<table>
+{{range .Lines}}<tr class="{{.Class}}"><td class="n">{{.Number}}</td><td class="c">{{.Count}}</td><td class="src">{{.Text}}</td><td class="b">{{.Note}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// writeCoverage writes the profile and the HTML view and prints a summary
+// for each file to standard error.
+func writeCoverage(coverages []*Coverage, profile string, html string) {
+	for _, c := range coverages {
+		var statements, branches = c.Percent()
+		fmt.Fprintf(os.Stderr, "%s: coverage: %.1f%% of statements, %.1f%% of branches\n", c.File, statements, branches)
+	}
+	if profile != "" {
+		var file, err = os.Create(profile)
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		if err := WriteProfile(file, coverages); err != nil {
+			panic(err)
+		}
+	}
+	if html != "" {
+		var file, err = os.Create(html)
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		if err := WriteHTML(file, coverages); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -1,14 +1,23 @@
 package main
 
-var docs = map[string]string{}
+// DocEntry documents a function, value or object, either of the standard
+// library or of a user module. Args is nil for anything that isn't callable.
+type DocEntry struct {
+	Name    string
+	Args    *Args
+	Desc    string
+	Returns string
+	Fields  []DocEntry
+}
+
+var docs = map[string]DocEntry{}
 
 func doc(name string, desc string) {
-	docs[name] = "# " + name + "\n" + desc
-	docs[name] += "\n\n<br>\n\n"
+	docs[name] = doc_field(name, desc)
 }
 
-func doc_str(name string, desc string) string {
-	return "## " + name + "\n" + desc
+func doc_field(name string, desc string) DocEntry {
+	return DocEntry{Name: name, Desc: desc}
 }
 
 type Args struct {
@@ -40,26 +49,17 @@ func ManyArgs(args ...string) Args {
 }
 
 func doc_fn(name string, args Args, desc string, returns string) {
-	docs[name] = "# " + name + "\n`" + name + "(" + args.String() + ") -> " + returns + "` : " + desc
-	docs[name] += "\n\n<br>\n\n"
+	docs[name] = doc_fn_field(name, args, desc, returns)
 }
 
-func doc_fn_str(name string, args Args, desc string, returns string) string {
-	return "## " + name + "\n`" + name + "(" + args.String() + ") -> " + returns + "` : " + desc
+func doc_fn_field(name string, args Args, desc string, returns string) DocEntry {
+	return DocEntry{Name: name, Args: &args, Desc: desc, Returns: returns}
 }
 
-func doc_obj(name string, desc string, fields ...string) {
-	docs[name] = "# " + name + "\n" + desc
-	for _, field := range fields {
-		docs[name] += "\n\n" + field
-	}
-	docs[name] += "\n\n<br>\n\n"
+func doc_obj(name string, desc string, fields ...DocEntry) {
+	docs[name] = doc_obj_field(name, desc, fields...)
 }
 
-func doc_obj_str(name string, desc string, fields ...string) string {
-	var result = "## " + name + "\n" + desc
-	for _, field := range fields {
-		result += "\n\n" + field
-	}
-	return result
+func doc_obj_field(name string, desc string, fields ...DocEntry) DocEntry {
+	return DocEntry{Name: name, Desc: desc, Fields: fields}
 }
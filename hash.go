@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Hash is the value of a hash. It keeps its entries in the order their keys
+// were first added, so iterating, printing and serializing a hash give the
+// same order every time. Keys are compared by value: 1 and 1.0 are the same
+// key, and arrays are keys by their elements.
+type Hash struct {
+	entries []hashEntry
+	// index maps the hashKey of each key to its position in entries
+	index map[interface{}]int
+	// readOnly is set on the library's namespaces, which programs cannot
+	// change
+	readOnly bool
+}
+
+type hashEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+// NewHash creates an empty hash.
+func NewHash() *Hash {
+	return &Hash{index: map[interface{}]int{}}
+}
+
+// hashFrom creates a hash holding the entries of m, with the keys in order.
+// It is meant for the hashes the library builds from Go map literals.
+func hashFrom(m map[interface{}]interface{}) *Hash {
+	var keys = make([]interface{}, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sortValues(keys)
+	var h = NewHash()
+	for _, key := range keys {
+		h.Set(key, m[key])
+	}
+	return h
+}
+
+// Len returns the number of entries in h.
+func (h *Hash) Len() int {
+	return len(h.entries)
+}
+
+// Get returns the value of key, and whether h has it.
+func (h *Hash) Get(key interface{}) (interface{}, bool) {
+	if n, ok := h.index[hashKey(key)]; ok {
+		return h.entries[n].value, true
+	}
+	return nil, false
+}
+
+// Value returns the value of key, or nil if h doesn't have it.
+func (h *Hash) Value(key interface{}) interface{} {
+	var value, _ = h.Get(key)
+	return value
+}
+
+// Set sets the value of key. A new key goes after the existing ones; an
+// existing key keeps its place.
+func (h *Hash) Set(key interface{}, value interface{}) {
+	if h.readOnly {
+		panic("Cannot change a library namespace")
+	}
+	var k = hashKey(key)
+	if n, ok := h.index[k]; ok {
+		h.entries[n].value = value
+		return
+	}
+	// later changes to an array or bytes must not change the key
+	switch original := key.(type) {
+	case []interface{}:
+		key = append([]interface{}{}, original...)
+	case []byte:
+		key = append([]byte{}, original...)
+	}
+	h.index[k] = len(h.entries)
+	h.entries = append(h.entries, hashEntry{key, value})
+}
+
+// Delete removes key from h, and reports whether it was there.
+func (h *Hash) Delete(key interface{}) bool {
+	if h.readOnly {
+		panic("Cannot change a library namespace")
+	}
+	var k = hashKey(key)
+	var n, ok = h.index[k]
+	if !ok {
+		return false
+	}
+	delete(h.index, k)
+	h.entries = append(h.entries[:n], h.entries[n+1:]...)
+	for j := n; j < len(h.entries); j++ {
+		h.index[hashKey(h.entries[j].key)] = j
+	}
+	return true
+}
+
+// At returns the key and value of the nth entry.
+func (h *Hash) At(n int) (interface{}, interface{}) {
+	return h.entries[n].key, h.entries[n].value
+}
+
+// Keys returns the keys of h in order.
+func (h *Hash) Keys() []interface{} {
+	var keys = make([]interface{}, len(h.entries))
+	for n, entry := range h.entries {
+		keys[n] = entry.key
+	}
+	return keys
+}
+
+// Copy returns a copy of h. Hashes among its values are copied too, so the
+// copy shares no hash with h.
+func (h *Hash) Copy() *Hash {
+	var result = NewHash()
+	for _, entry := range h.entries {
+		if value, ok := entry.value.(*Hash); ok {
+			result.Set(entry.key, value.Copy())
+		} else {
+			result.Set(entry.key, entry.value)
+		}
+	}
+	return result
+}
+
+// Freeze makes h and the hashes among its values read-only.
+func (h *Hash) Freeze() {
+	h.readOnly = true
+	for _, entry := range h.entries {
+		if value, ok := entry.value.(*Hash); ok {
+			value.Freeze()
+		}
+	}
+}
+
+func (h *Hash) String() string {
+	var result strings.Builder
+	result.WriteString("map[")
+	for n, entry := range h.entries {
+		if n > 0 {
+			result.WriteString(" ")
+		}
+		result.WriteString(stringify(entry.key) + ":" + stringify(entry.value))
+	}
+	result.WriteString("]")
+	return result.String()
+}
+
+// MarshalJSON writes h as a JSON object, in order. Keys that are not
+// strings are written as they print.
+func (h *Hash) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("{")
+	for n, entry := range h.entries {
+		if n > 0 {
+			buffer.WriteString(",")
+		}
+		var key, err = json.Marshal(stringify(entry.key))
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		buffer.WriteString(":")
+		buffer.Write(value)
+	}
+	buffer.WriteString("}")
+	return buffer.Bytes(), nil
+}
+
+// parseJSON decodes data, turning JSON objects into hashes that keep the
+// order of their keys.
+func parseJSON(data []byte) (interface{}, error) {
+	var decoder = json.NewDecoder(bytes.NewReader(data))
+	var value, err = decodeJSON(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("invalid character after top-level value")
+	}
+	return value, nil
+}
+
+func decodeJSON(decoder *json.Decoder) (interface{}, error) {
+	var token, err = decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('['):
+		var array = []interface{}{}
+		for decoder.More() {
+			var element, err = decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, element)
+		}
+		_, err = decoder.Token()
+		return array, err
+	case json.Delim('{'):
+		var hash = NewHash()
+		for decoder.More() {
+			var key, err = decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(key, value)
+		}
+		_, err = decoder.Token()
+		return hash, err
+	}
+	return token, nil
+}
+
+// bytesKey is the hashKey of bytes.
+type bytesKey string
+
+// compositeKey is the hashKey of an array: its elements' keys, encoded in
+// a string so that it can be a Go map key.
+type compositeKey string
+
+// hashKey returns the form of value used to look it up in a hash, so that
+// keys which are equal find the same entry: ints are keyed by their exact
+// value, floats with an exact integral value become that int, bytes become
+// bytesKeys and arrays become compositeKeys. Hashes, sets and subs cannot be
+// keys.
+func hashKey(value interface{}) interface{} {
+	switch x := value.(type) {
+	case int:
+		return x
+	case int64:
+		return int(x)
+	}
+	if x, ok := numeric(value); ok {
+		if x == math.Trunc(x) && math.Abs(x) < 1<<63 {
+			return int(x)
+		}
+		return x
+	}
+	if data, ok := value.([]byte); ok {
+		return bytesKey(data)
+	}
+	if array, ok := value.([]interface{}); ok {
+		var result strings.Builder
+		for _, element := range array {
+			switch key := hashKey(element).(type) {
+			case string:
+				result.WriteString("s" + strconv.Quote(key))
+			case compositeKey:
+				result.WriteString("a" + strconv.Quote(string(key)))
+			default:
+				if reflect.ValueOf(key).Kind() == reflect.Ptr {
+					fmt.Fprintf(&result, "%T(%p)", key, key)
+				} else {
+					fmt.Fprintf(&result, "%T(%#v)", key, key)
+				}
+			}
+			result.WriteString(",")
+		}
+		return compositeKey(result.String())
+	}
+	switch value.(type) {
+	case *Hash, *Set:
+		panic("Hash keys must be numbers, strings, bytes, booleans, nil, arrays or objects")
+	}
+	if value != nil && !reflect.TypeOf(value).Comparable() {
+		panic("Hash keys must be numbers, strings, bytes, booleans, nil, arrays or objects")
+	}
+	return value
+}
@@ -2,25 +2,114 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 type Interpreter struct {
-	Program   []Statement
+	Program  []Statement
+	Coverage *Coverage
+	// Frame is the frame of the running task. Tasks take turns: the running
+	// task holds lock, and puts its own frame here when it takes it.
+	*Frame
+	lock sync.Mutex
+	// tasks is the number of tasks spawned; steps counts statements, so
+	// that a busy task can let the others run.
+	tasks int
+	steps int
+	// cancelled is set by Cancel, from any goroutine, to stop the program
+	// at its next statement.
+	cancelled atomic.Bool
+	// live is the number of tasks that haven't finished, and waiting the
+	// number of them in wait; woken counts the waits that have ended.
+	// deadlock is signalled once every live task is waiting for good.
+	live     atomic.Int32
+	waiting  atomic.Int32
+	woken    atomic.Int64
+	deadlock atomic.Pointer[deadlockSignal]
+}
+
+// Frame is the state of one task: its scopes, the position it is running
+// at and the generator it is in. The global scope, Variables[0], is shared
+// by every task.
+type Frame struct {
 	Variables []map[string]interface{}
+	// Pos is the position of the statement being executed, or of the
+	// interpolated expression being evaluated. It is left pointing at the
+	// failing code when a runtime error unwinds.
+	Pos Pos
+	// generator is the generator whose body is running, if any.
+	generator *Generator
+	// branchValue is the value of the last ValueStatement run.
+	branchValue interface{}
 }
 
 type ReturnValue struct {
 	Value interface{}
 }
 
+// constant holds the value of a const, or of a library binding, in a
+// scope. Reading the variable gives the value; assigning to it is an
+// error. A program may declare a name of its own over a library binding.
+type constant struct {
+	value   interface{}
+	library bool
+}
+
+// defined reports whether name is declared in scope, so that declaring it
+// again is an error. Library bindings don't count.
+func defined(scope map[string]interface{}, name string) bool {
+	var value, ok = scope[name]
+	if c, isConstant := value.(constant); isConstant && c.library {
+		return false
+	}
+	return ok
+}
+
+// rebind sets name in scope for a for loop or a when pattern, which may
+// reuse a variable of the scope but not a constant.
+func rebind(scope map[string]interface{}, name string, value interface{}) {
+	if c, ok := scope[name].(constant); ok && !c.library {
+		panic("Cannot assign to constant: " + name)
+	}
+	scope[name] = value
+}
+
+// NewInterpreter creates an interpreter for program. The caller is its
+// first task, and holds its lock.
 func NewInterpreter(program []Statement) *Interpreter {
-	var result = &Interpreter{Program: program}
+	var result = &Interpreter{Program: program, Frame: &Frame{}}
+	result.deadlock.Store(newDeadlockSignal())
 	result.Variables = append(result.Variables, make(map[string]interface{}))
+	result.live.Store(1)
+	result.lock.Lock()
 	return result
 }
 
+// Cancel stops the program running in i: every task raises an error when
+// it next executes a statement. A task blocked waiting for a channel or
+// another task stays blocked.
+func (i *Interpreter) Cancel() {
+	i.cancelled.Store(true)
+}
+
+// block lets other tasks run while wait waits for something, and then
+// takes the interpreter back for the calling task.
+func (i *Interpreter) block(wait func()) {
+	var frame = i.Frame
+	i.lock.Unlock()
+	defer func() {
+		i.lock.Lock()
+		i.Frame = frame
+	}()
+	wait()
+}
+
 func (i *Interpreter) Run() {
 	for _, stmt := range i.Program {
 		i.exec(stmt)
@@ -28,10 +117,36 @@ func (i *Interpreter) Run() {
 }
 
 func (i *Interpreter) exec(stmt Statement) {
+	if i.cancelled.Load() {
+		panic("Program cancelled")
+	}
+	if i.tasks > 0 {
+		i.steps++
+		if i.steps%1000 == 0 {
+			i.block(runtime.Gosched)
+		}
+	}
+	i.Coverage.hit(stmt)
+	i.Pos = stmt.PosFrom()
 	switch stmt := stmt.(type) {
+	case *ValueStatement:
+		i.branchValue = i.eval(stmt.Value)
 	case *MyStatement:
-		if _, ok := i.Variables[len(i.Variables)-1][stmt.Name]; !ok {
-			if stmt.Value != nil {
+		if stmt.Pattern != nil {
+			var scope = i.Variables[len(i.Variables)-1]
+			i.destructure(stmt.Pattern, i.eval(*stmt.Value), func(name string, value interface{}) {
+				if defined(scope, name) {
+					panic("Variable already defined: " + name)
+				}
+				if stmt.Const {
+					value = constant{value: value}
+				}
+				scope[name] = value
+			})
+		} else if !defined(i.Variables[len(i.Variables)-1], stmt.Name) {
+			if stmt.Const {
+				i.Variables[len(i.Variables)-1][stmt.Name] = constant{value: i.eval(*stmt.Value)}
+			} else if stmt.Value != nil {
 				i.Variables[len(i.Variables)-1][stmt.Name] = i.eval(*stmt.Value)
 			} else {
 				i.Variables[len(i.Variables)-1][stmt.Name] = nil
@@ -40,71 +155,74 @@ func (i *Interpreter) exec(stmt Statement) {
 			panic("Variable already defined: " + stmt.Name)
 		}
 	case *SubStatement:
-		if _, ok := i.Variables[len(i.Variables)-1][stmt.Name]; !ok {
-			i.Variables[len(i.Variables)-1][stmt.Name] = func(args ...interface{}) (v interface{}) {
-				var prev = i.Variables
-				i.Variables = make([]map[string]interface{}, 2)
-				i.Variables[0] = prev[0]
-				i.Variables[1] = make(map[string]interface{})
-				for j, arg := range stmt.Params {
-					i.Variables[1][arg] = args[j]
-				}
-				defer func() {
-					i.Variables = prev
-				}()
-				defer func() {
-					if r := recover(); r != nil {
-						if returnValue, ok := r.(ReturnValue); ok {
-							v = returnValue.Value
-						} else {
-							panic(r)
-						}
-					}
-				}()
-				for _, s := range stmt.Body {
-					i.exec(s)
-				}
-				return nil
-			}
+		if !defined(i.Variables[len(i.Variables)-1], stmt.Name) {
+			i.Variables[len(i.Variables)-1][stmt.Name] = i.sub(stmt, nil, nil)
 		} else {
 			panic("Variable already defined: " + stmt.Name)
 		}
+	case *ClassStatement:
+		if defined(i.Variables[len(i.Variables)-1], stmt.Name) {
+			panic("Variable already defined: " + stmt.Name)
+		}
+		var class = &ClassValue{Name: stmt.Name, Methods: map[string]*SubStatement{}, interpreter: i}
+		if stmt.Parent != nil {
+			var parent, ok = i.eval(stmt.Parent).(*ClassValue)
+			if !ok {
+				panic("Parent of " + stmt.Name + " is not a class")
+			}
+			class.Parent = parent
+		}
+		for _, method := range stmt.Methods {
+			class.Methods[method.Name] = method
+		}
+		i.Variables[len(i.Variables)-1][stmt.Name] = class
 	case *IfStatement:
 		if truthy(i.eval(stmt.Conditions)) {
+			i.Coverage.branch(stmt, 0)
 			for _, s := range stmt.Then {
 				i.exec(s)
 			}
 		} else {
-			for _, elseif := range stmt.ElseIfs {
+			for j, elseif := range stmt.ElseIfs {
 				if truthy(i.eval(elseif.Condition)) {
+					i.Coverage.branch(stmt, j+1)
 					for _, s := range elseif.Then {
 						i.exec(s)
 					}
 					return
 				}
 			}
+			i.Coverage.branch(stmt, len(stmt.ElseIfs)+1)
 			for _, s := range stmt.Else_ {
 				i.exec(s)
 			}
 		}
 	case *UnlessStatement:
 		if !truthy(i.eval(stmt.Condition)) {
+			i.Coverage.branch(stmt, 0)
 			for _, s := range stmt.Then {
 				i.exec(s)
 			}
 		} else {
-			for _, elseif := range stmt.ElseIfs {
+			for j, elseif := range stmt.ElseIfs {
 				if truthy(i.eval(elseif.Condition)) {
+					i.Coverage.branch(stmt, j+1)
 					for _, s := range elseif.Then {
 						i.exec(s)
 					}
 					return
 				}
 			}
+			i.Coverage.branch(stmt, len(stmt.ElseIfs)+1)
 			for _, s := range stmt.Else_ {
 				i.exec(s)
 			}
 		}
+	case *YieldStatement:
+		if i.generator == nil {
+			panic("yield outside of a generator")
+		}
+		i.generator.yield(i.eval(stmt.Value))
 	case *ReturnStatement:
 		if stmt.Value != nil {
 			panic(ReturnValue{i.eval(*stmt.Value)})
@@ -125,16 +243,72 @@ func (i *Interpreter) exec(stmt Statement) {
 		}
 	case *ForStatement:
 		var value = i.eval(stmt.Expression)
+		var scope = i.Variables[len(i.Variables)-1]
+		var bind = func(element interface{}) {
+			if stmt.Pattern == nil {
+				rebind(scope, stmt.Name, element)
+				return
+			}
+			i.destructure(stmt.Pattern, element, func(name string, value interface{}) {
+				rebind(scope, name, value)
+			})
+		}
 		if array, ok := value.([]interface{}); ok {
 			for _, element := range array {
-				i.Variables[len(i.Variables)-1][stmt.Name] = element
+				bind(element)
+				for _, s := range stmt.Body {
+					i.exec(s)
+				}
+			}
+		} else if r, ok := value.(Range); ok {
+			for n := 0; n < r.Len(); n++ {
+				bind(r.At(n))
+				for _, s := range stmt.Body {
+					i.exec(s)
+				}
+			}
+		} else if str, ok := value.(string); ok {
+			for _, char := range str {
+				bind(string(char))
 				for _, s := range stmt.Body {
 					i.exec(s)
 				}
 			}
-		} else if hash, ok := value.(map[interface{}]interface{}); ok {
-			for key, element := range hash {
-				i.Variables[len(i.Variables)-1][stmt.Name] = []interface{}{key, element}
+		} else if c, ok := value.(*Channel); ok {
+			for element, ok := i.recv(c); ok; element, ok = i.recv(c) {
+				bind(element)
+				for _, s := range stmt.Body {
+					i.exec(s)
+				}
+			}
+		} else if set, ok := value.(*Set); ok {
+			for n := 0; n < set.Len(); n++ {
+				bind(set.At(n))
+				for _, s := range stmt.Body {
+					i.exec(s)
+				}
+			}
+		} else if data, ok := value.([]byte); ok {
+			for _, b := range data {
+				bind(int(b))
+				for _, s := range stmt.Body {
+					i.exec(s)
+				}
+			}
+		} else if it, ok := iterator(value); ok {
+			if g, ok := it.(*Generator); ok {
+				defer g.Close()
+			}
+			for element, ok := it.Next(); ok; element, ok = it.Next() {
+				bind(element)
+				for _, s := range stmt.Body {
+					i.exec(s)
+				}
+			}
+		} else if hash, ok := value.(*Hash); ok {
+			for n := 0; n < hash.Len(); n++ {
+				var key, element = hash.At(n)
+				bind([]interface{}{key, element})
 				for _, s := range stmt.Body {
 					i.exec(s)
 				}
@@ -161,111 +335,45 @@ func (i *Interpreter) exec(stmt Statement) {
 			}
 		}
 	case *WhenStatement:
-		for _, branch := range stmt.Cases {
+		for j, branch := range stmt.Cases {
 			if truthy(i.eval(branch.Condition)) {
+				i.Coverage.branch(stmt, j)
 				for _, s := range branch.Then {
 					i.exec(s)
 				}
 				return
 			}
 		}
+		i.Coverage.branch(stmt, len(stmt.Cases))
 		for _, s := range stmt.Else_ {
 			i.exec(s)
 		}
 	case *WhenMatchStatement:
 		var value = i.eval(stmt.Value)
-		for _, branch := range stmt.Cases {
-			if i.eval(branch.Condition) == value {
-				for _, s := range branch.Then {
-					i.exec(s)
-				}
+		for j, branch := range stmt.Cases {
+			if scope, ok := i.matchBranch(branch, value); ok {
+				i.Coverage.branch(stmt, j)
+				i.inScope(scope, func() {
+					for _, s := range branch.Then {
+						i.exec(s)
+					}
+				})
 				return
 			}
 		}
+		i.Coverage.branch(stmt, len(stmt.Cases))
 		for _, s := range stmt.Else_ {
 			i.exec(s)
 		}
 	case *CallStatement:
-		var function = i.eval(stmt.Function)
-		var args = make([]interface{}, len(stmt.Args))
-		for j, arg := range stmt.Args {
-			args[j] = i.eval(arg)
-		}
-		if anyFn, ok := function.(func(...interface{}) interface{}); ok {
-			anyFn(args...)
-		} else {
-			// use go's reflection to call method
-			reflectValue := reflect.ValueOf(function)
-			if reflectValue.Kind() == reflect.Func {
-				var reflectArgs = make([]reflect.Value, len(args))
-				for j, arg := range args {
-					reflectArgs[j] = reflect.ValueOf(arg)
-				}
-				reflectValue.Call(reflectArgs)
-			} else {
-				panic("Not a function: " + fmt.Sprintf("%T", function))
-			}
+		var function, ok = i.link(stmt.Function)
+		if ok {
+			callFunction(function, i.evalArgs(stmt.Args))
 		}
 	case *AssignmentStatement:
-		switch stmt.Left.(type) {
-		case *Variable:
-			var value = i.eval(stmt.Value)
-			var scope = len(i.Variables) - 1
-			for scope >= 0 {
-				if _, ok := i.Variables[scope][stmt.Left.(*Variable).Name]; ok {
-					i.Variables[scope][stmt.Left.(*Variable).Name] = value
-					return
-				}
-				scope--
-			}
-			panic("Undefined variable: " + stmt.Left.(*Variable).Name)
-		case *Index:
-			var left = stmt.Left.(*Index)
-			var value = i.eval(left.Left)
-			var index = i.eval(left.Index)
-			if array, ok := value.([]interface{}); ok {
-				if idx, ok := index.(float64); ok {
-					var idx = int(idx)
-					if idx >= 0 && idx < len(array) {
-						array[idx] = i.eval(stmt.Value)
-						return
-					}
-					panic("Index out of range: " + strconv.Itoa(idx))
-				}
-				panic("Index must be an integer")
-			}
-			// use reflection to index
-			reflectValue := reflect.ValueOf(value)
-			if reflectValue.Kind() == reflect.Array || reflectValue.Kind() == reflect.Slice {
-				if idx, ok := index.(float64); ok {
-					var idx = int(idx)
-					if idx >= 0 && idx < reflectValue.Len() {
-						reflectValue.Index(idx).Set(reflect.ValueOf(i.eval(stmt.Value)))
-						return
-					}
-					panic("Index out of range: " + strconv.Itoa(idx))
-				}
-				panic("Index must be an integer")
-			} else if reflectValue.Kind() == reflect.Map {
-				var reflectResult = reflectValue.MapIndex(reflect.ValueOf(index))
-				if reflectResult.IsValid() {
-					reflectResult.Set(reflect.ValueOf(i.eval(stmt.Value)))
-					return
-				}
-				panic("Key not found: " + fmt.Sprintf("%v", index))
-			}
-			panic("Indexing not supported for type: " + fmt.Sprintf("%T", value))
-		case *Member:
-			var left = stmt.Left.(*Member)
-			var value = i.eval(left.Left)
-			if hash, ok := value.(map[interface{}]interface{}); ok {
-				hash[left.Member] = i.eval(stmt.Value)
-				return
-			}
-			panic("Member access not supported for type: " + fmt.Sprintf("%T", value))
-		default:
-			panic("Assignment not supported for type: " + fmt.Sprintf("%T", stmt.Left))
-		}
+		i.assign(stmt.Left, stmt.Operator, stmt.Value)
+	case *SpawnExpression:
+		i.eval(stmt)
 	case *Increment:
 		i.inc(stmt)
 	case *Decrement:
@@ -275,166 +383,688 @@ func (i *Interpreter) exec(stmt Statement) {
 	}
 }
 
-func (i *Interpreter) inc(node *Increment) {
-	var byExpr = i.eval(node.By)
-	if _, ok := byExpr.(float64); !ok {
-		panic("By must be a number")
+func (i *Interpreter) inc(node *Increment) interface{} {
+	var get, set = i.reference(node.Left)
+	var result = arithmetic(Plus, get(), i.eval(node.By))
+	set(result)
+	return result
+}
+
+func (i *Interpreter) dec(node *Decrement) interface{} {
+	var get, set = i.reference(node.Left)
+	var result = arithmetic(Minus, get(), i.eval(node.By))
+	set(result)
+	return result
+}
+
+// destructure matches value against pattern and calls bind for every name
+// the pattern binds. It panics if the value doesn't have the pattern's
+// shape.
+func (i *Interpreter) destructure(pattern Pattern, value interface{}, bind func(name string, value interface{})) {
+	switch pattern := pattern.(type) {
+	case *NamePattern:
+		if pattern.Name != "_" {
+			bind(pattern.Name, value)
+		}
+	case *ArrayPattern:
+		var reflectValue = reflect.ValueOf(value)
+		if value == nil || reflectValue.Kind() != reflect.Slice && reflectValue.Kind() != reflect.Array {
+			panic("Cannot destructure " + fmt.Sprintf("%T", value) + " as an array")
+		}
+		if reflectValue.Len() != len(pattern.Elements) && (pattern.Rest == nil || reflectValue.Len() < len(pattern.Elements)) {
+			panic(fmt.Sprintf("Cannot destructure %d values into %d names", reflectValue.Len(), len(pattern.Elements)))
+		}
+		for n, element := range pattern.Elements {
+			i.destructure(element, reflectValue.Index(n).Interface(), bind)
+		}
+		if pattern.Rest != nil {
+			i.destructure(pattern.Rest, rest(reflectValue, len(pattern.Elements)), bind)
+		}
+	case *HashPattern:
+		var hash, ok = value.(*Hash)
+		if !ok {
+			panic("Cannot destructure " + fmt.Sprintf("%T", value) + " as a hash")
+		}
+		for n, key := range pattern.Keys {
+			var element, ok = hash.Get(key)
+			if !ok {
+				panic("Key not found: " + key)
+			}
+			i.destructure(pattern.Values[n], element, bind)
+		}
+	default:
+		var bindings = map[string]interface{}{}
+		if !i.match(pattern, value, bindings) {
+			panic("Value does not match pattern: " + repr(value))
+		}
+		for name, value := range bindings {
+			bind(name, value)
+		}
+	}
+}
+
+// rest returns the elements of an array from start on, as a clam array.
+func rest(array reflect.Value, start int) []interface{} {
+	var result = []interface{}{}
+	for n := start; n < array.Len(); n++ {
+		result = append(result, array.Index(n).Interface())
+	}
+	return result
+}
+
+// match reports whether value matches pattern, adding the names the pattern
+// binds to bindings.
+func (i *Interpreter) match(pattern Pattern, value interface{}, bindings map[string]interface{}) bool {
+	switch pattern := pattern.(type) {
+	case *NamePattern:
+		if pattern.Name != "_" {
+			bindings[pattern.Name] = value
+		}
+		return true
+	case *LiteralPattern:
+		return equal(i.eval(pattern.Value), value)
+	case *RangePattern:
+		var low, high = i.eval(pattern.Low), i.eval(pattern.High)
+		if rank(value) != rank(low) || rank(value) != rank(high) {
+			return false
+		}
+		var end = order(value, high)
+		return order(low, value) <= 0 && (end < 0 || !pattern.Exclusive && end == 0)
+	case *TypePattern:
+		if !hasType(value, pattern.Type) {
+			return false
+		}
+		if pattern.Name != "" && pattern.Name != "_" {
+			bindings[pattern.Name] = value
+		}
+		return true
+	case *ArrayPattern:
+		var reflectValue = reflect.ValueOf(value)
+		if value == nil || reflectValue.Kind() != reflect.Slice && reflectValue.Kind() != reflect.Array {
+			return false
+		}
+		if reflectValue.Len() < len(pattern.Elements) || pattern.Rest == nil && reflectValue.Len() > len(pattern.Elements) {
+			return false
+		}
+		for n, element := range pattern.Elements {
+			if !i.match(element, reflectValue.Index(n).Interface(), bindings) {
+				return false
+			}
+		}
+		return pattern.Rest == nil || i.match(pattern.Rest, rest(reflectValue, len(pattern.Elements)), bindings)
+	case *HashPattern:
+		var hash, ok = value.(*Hash)
+		if !ok {
+			return false
+		}
+		for n, key := range pattern.Keys {
+			var element, ok = hash.Get(key)
+			if !ok || !i.match(pattern.Values[n], element, bindings) {
+				return false
+			}
+		}
+		return true
+	}
+	panic("Pattern not supported: " + fmt.Sprintf("%T", pattern))
+}
+
+// matchBranch reports whether value matches the case's pattern and guard,
+// and returns the scope holding the names the pattern binds. The guard and
+// the body of the case run in that scope, so the names are not seen outside
+// it.
+func (i *Interpreter) matchBranch(branch MatchBranch, value interface{}) (map[string]interface{}, bool) {
+	var bindings = map[string]interface{}{}
+	if !i.match(branch.Pattern, value, bindings) {
+		return nil, false
 	}
-	var by = byExpr.(float64)
-	switch target := node.Left.(type) {
+	var ok = true
+	if branch.Guard != nil {
+		i.inScope(bindings, func() {
+			ok = truthy(i.eval(branch.Guard))
+		})
+	}
+	return bindings, ok
+}
+
+// inScope runs f with scope as the innermost scope.
+func (i *Interpreter) inScope(scope map[string]interface{}, f func()) {
+	var outer = i.Variables
+	i.Variables = append(outer[:len(outer):len(outer)], scope)
+	defer func() {
+		i.Variables = outer
+	}()
+	f()
+}
+
+// lookup returns the value of the variable called name, and false if there
+// is none in scope.
+func (i *Interpreter) lookup(name string) (interface{}, bool) {
+	for scope := len(i.Variables) - 1; scope >= 0; scope-- {
+		if value, ok := i.Variables[scope][name]; ok {
+			if c, ok := value.(constant); ok {
+				return c.value, true
+			}
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// hasType reports whether value has the type called name, which is one of
+// typeNames.
+func hasType(value interface{}, name string) bool {
+	switch name {
+	case "str":
+		_, ok := value.(string)
+		return ok
+	case "num":
+		_, ok := numeric(value)
+		return ok
+	case "int":
+		_, ok := value.(int)
+		return ok
+	case "float":
+		_, ok := value.(float64)
+		return ok
+	case "bool":
+		_, ok := value.(bool)
+		return ok
+	case "bytes":
+		_, ok := value.([]byte)
+		return ok
+	case "array":
+		if _, ok := value.([]byte); ok {
+			return false
+		}
+		return value != nil && reflect.ValueOf(value).Kind() == reflect.Slice
+	case "hash":
+		if _, ok := value.(*Hash); ok {
+			return true
+		}
+		return value != nil && reflect.ValueOf(value).Kind() == reflect.Map
+	case "set":
+		_, ok := value.(*Set)
+		return ok
+	case "sub":
+		return value != nil && reflect.ValueOf(value).Kind() == reflect.Func
+	}
+	return false
+}
+
+// compoundOperators maps each compound assignment to the operator it
+// applies.
+var compoundOperators = map[TokenType]TokenType{
+	PlusAssign:     Plus,
+	MinusAssign:    Minus,
+	MultiplyAssign: Multiply,
+	DivideAssign:   Divide,
+	ModuloAssign:   Modulo,
+}
+
+// assign stores the value of expr in target and returns it. For compound
+// operators the old value is combined with it first; AppendAssign appends
+// the value, as a string, to the old one.
+func (i *Interpreter) assign(target Expression, operator TokenType, expr Expression) interface{} {
+	var get, set = i.reference(target)
+	var value = i.eval(expr)
+	switch operator {
+	case Assign:
+	case AppendAssign:
+		value = stringify(get()) + stringify(value)
+	case PlusAssign:
+		value = add(get(), value)
+	default:
+		value = arithmetic(compoundOperators[operator], get(), value)
+	}
+	set(value)
+	return value
+}
+
+// reference evaluates the sub-expressions of an assignment target once and
+// returns functions that read and write the place it denotes.
+func (i *Interpreter) reference(target Expression) (get func() interface{}, set func(interface{})) {
+	switch target := target.(type) {
 	case *Variable:
 		var scope = len(i.Variables) - 1
 		for scope >= 0 {
-			if value, ok := i.Variables[scope][target.Name]; ok {
-				if value, ok := value.(float64); ok {
-					i.Variables[scope][target.Name] = value + by
-					return
+			if _, ok := i.Variables[scope][target.Name]; ok {
+				var variables = i.Variables[scope]
+				if c, ok := variables[target.Name].(constant); ok {
+					get = func() interface{} {
+						return c.value
+					}
+					set = func(value interface{}) {
+						panic("Cannot assign to constant: " + target.Name)
+					}
+					return get, set
 				}
-				panic("Variable must be a number")
+				get = func() interface{} {
+					return variables[target.Name]
+				}
+				set = func(value interface{}) {
+					variables[target.Name] = value
+				}
+				return get, set
 			}
 			scope--
 		}
 		panic("Undefined variable: " + target.Name)
 	case *Index:
+		if target.Optional {
+			panic("Cannot assign through ?[")
+		}
 		var value = i.eval(target.Left)
 		var index = i.eval(target.Index)
 		if array, ok := value.([]interface{}); ok {
-			if idx, ok := index.(float64); ok {
-				var idx = int(idx)
+			if idx, ok := integer(index); ok {
 				if idx >= 0 && idx < len(array) {
-					if value, ok := array[idx].(float64); ok {
-						array[idx] = value + by
-						return
+					get = func() interface{} {
+						return array[idx]
 					}
-					panic("Value must be a number")
+					set = func(value interface{}) {
+						array[idx] = value
+					}
+					return get, set
 				}
 				panic("Index out of range: " + strconv.Itoa(idx))
 			}
 			panic("Index must be an integer")
-		} else if hash, ok := value.(map[interface{}]interface{}); ok {
-			if _, ok := hash[index]; ok {
-				panic("Cannot increment hash value")
+		} else if object, ok := value.(*Object); ok {
+			get = func() interface{} {
+				if result, ok := object.hook("__index", index); ok {
+					return result
+				}
+				panic(object.Class.Name + " has no __index method")
+			}
+			set = func(value interface{}) {
+				if _, ok := object.hook("__setindex", index, value); !ok {
+					panic(object.Class.Name + " has no __setindex method")
+				}
+			}
+			return get, set
+		} else if hash, ok := value.(*Hash); ok {
+			get = func() interface{} {
+				if result, ok := hash.Get(index); ok {
+					return result
+				}
+				panic("Key not found: " + fmt.Sprintf("%v", index))
+			}
+			set = func(value interface{}) {
+				hash.Set(index, value)
+			}
+			return get, set
+		} else if data, ok := value.([]byte); ok {
+			var idx, ok = integer(index)
+			if !ok {
+				panic("Index must be an integer")
 			}
-			panic("Key not found: " + index.(string))
+			if idx < 0 || idx >= len(data) {
+				panic("Index out of range: " + strconv.Itoa(idx))
+			}
+			get = func() interface{} {
+				return int(data[idx])
+			}
+			set = func(value interface{}) {
+				var b, ok = integer(value)
+				if !ok || b < 0 || b > 255 {
+					panic("A byte must be an integer from 0 to 255")
+				}
+				data[idx] = byte(b)
+			}
+			return get, set
 		}
 		// use reflection to index
 		reflectValue := reflect.ValueOf(value)
 		if reflectValue.Kind() == reflect.Array || reflectValue.Kind() == reflect.Slice {
-			if idx, ok := index.(float64); ok {
-				var idx = int(idx)
+			if idx, ok := integer(index); ok {
 				if idx >= 0 && idx < reflectValue.Len() {
-					reflectValue.Index(idx).Set(reflect.ValueOf(reflectValue.Index(idx).Interface().(float64) + by))
-					return
+					get = func() interface{} {
+						return reflectValue.Index(idx).Interface()
+					}
+					set = func(value interface{}) {
+						reflectValue.Index(idx).Set(reflect.ValueOf(value))
+					}
+					return get, set
 				}
 				panic("Index out of range: " + strconv.Itoa(idx))
 			}
 			panic("Index must be an integer")
 		} else if reflectValue.Kind() == reflect.Map {
-			var reflectResult = reflectValue.MapIndex(reflect.ValueOf(index))
-			if reflectResult.IsValid() {
-				reflectResult.Set(reflect.ValueOf(reflectResult.Interface().(float64) + by))
-				return
+			var key = reflect.ValueOf(index)
+			get = func() interface{} {
+				var reflectResult = reflectValue.MapIndex(key)
+				if reflectResult.IsValid() {
+					return reflectResult.Interface()
+				}
+				panic("Key not found: " + fmt.Sprintf("%v", index))
 			}
-			panic("Key not found: " + fmt.Sprintf("%v", index))
+			set = func(value interface{}) {
+				reflectValue.SetMapIndex(key, reflect.ValueOf(value))
+			}
+			return get, set
 		}
 		panic("Indexing not supported for type: " + fmt.Sprintf("%T", value))
 	case *Member:
+		if target.Optional {
+			panic("Cannot assign through ?.")
+		}
 		var value = i.eval(target.Left)
-		if hash, ok := value.(map[interface{}]interface{}); ok {
-			if value, ok := hash[target.Member]; ok {
-				if value, ok := value.(float64); ok {
-					hash[target.Member] = value + by
-					return
+		if hash, ok := value.(*Hash); ok {
+			get = func() interface{} {
+				if result, ok := hash.Get(target.Member); ok {
+					return result
 				}
-				panic("Value must be a number")
+				panic("Key not found: " + target.Member)
+			}
+			set = func(value interface{}) {
+				hash.Set(target.Member, value)
+			}
+			return get, set
+		} else if object, ok := value.(*Object); ok {
+			get = func() interface{} {
+				if result, ok := object.member(target.Member); ok {
+					return result
+				}
+				panic(object.Class.Name + " has no member " + target.Member)
+			}
+			set = func(value interface{}) {
+				object.Fields.Set(target.Member, value)
 			}
-			panic("Key not found: " + target.Member)
+			return get, set
 		}
 		panic("Member access not supported for type: " + fmt.Sprintf("%T", value))
-	default:
-		panic("Increment not supported for type: " + fmt.Sprintf("%T", node.Left))
 	}
+	panic("Assignment not supported for type: " + fmt.Sprintf("%T", target))
 }
 
-func (i *Interpreter) dec(node *Decrement) {
-	var byExpr = i.eval(node.By)
-	if _, ok := byExpr.(float64); !ok {
-		panic("By must be a number")
-	}
-	var by = byExpr.(float64)
-	switch target := node.Left.(type) {
-	case *Variable:
-		var scope = len(i.Variables) - 1
-		for scope >= 0 {
-			if value, ok := i.Variables[scope][target.Name]; ok {
-				if value, ok := value.(float64); ok {
-					i.Variables[scope][target.Name] = value - by
-					return
+// sub creates the function for a sub. For a method, self is the receiver
+// and owner the class defining the method; both are nil for plain subs.
+func (i *Interpreter) sub(stmt *SubStatement, self *Object, owner *ClassValue) func(args ...interface{}) interface{} {
+	return func(args ...interface{}) (v interface{}) {
+		var prev = i.Variables
+		var pos = i.Pos
+		defer func() {
+			i.Variables = prev
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				if returnValue, ok := r.(ReturnValue); ok {
+					v = returnValue.Value
+				} else {
+					panic(r)
 				}
-				panic("Variable must be a number")
 			}
-			scope--
+			i.Pos = pos
+		}()
+		i.Variables = make([]map[string]interface{}, 2)
+		i.Variables[0] = prev[0]
+		i.Variables[1] = make(map[string]interface{})
+		if self != nil {
+			i.Variables[1]["self"] = self
+			i.Variables[1]["super"] = &Super{Class: owner.Parent, Self: self}
 		}
-		panic("Undefined variable: " + target.Name)
-	case *Index:
-		var value = i.eval(target.Left)
-		var index = i.eval(target.Index)
-		if array, ok := value.([]interface{}); ok {
-			if idx, ok := index.(float64); ok {
-				var idx = int(idx)
-				if idx >= 0 && idx < len(array) {
-					if value, ok := array[idx].(float64); ok {
-						array[idx] = value - by
-						return
-					}
-					panic("Value must be a number")
+		i.bind(stmt.Name, stmt.Params, args, i.Variables[1])
+		if stmt.Generator {
+			return newGenerator(i, i.Variables, stmt.Body)
+		}
+		for _, s := range stmt.Body {
+			i.exec(s)
+		}
+		return nil
+	}
+}
+
+// callFunction calls a clam sub or a Go function from the library. Go
+// functions with several results return them packed in an array.
+func callFunction(function interface{}, args []interface{}) interface{} {
+	if anyFn, ok := function.(func(...interface{}) interface{}); ok {
+		return anyFn(args...)
+	}
+	if class, ok := function.(*ClassValue); ok {
+		return class.New(args)
+	}
+	if object, ok := function.(*Object); ok {
+		if result, ok := object.hook("__call", args...); ok {
+			return result
+		}
+		panic(object.Class.Name + " has no __call method")
+	}
+	// use go's reflection to call method
+	reflectValue := reflect.ValueOf(function)
+	if reflectValue.Kind() != reflect.Func {
+		panic("Not a function: " + fmt.Sprintf("%T", function))
+	}
+	var reflectType = reflectValue.Type()
+	if len(args) > 0 {
+		if _, ok := args[len(args)-1].(namedArgs); ok {
+			panic("Named arguments can only be passed to subs")
+		}
+	}
+	if reflectType.IsVariadic() && len(args) < reflectType.NumIn()-1 {
+		panic(fmt.Sprintf("Too few arguments: expected at least %d, got %d", reflectType.NumIn()-1, len(args)))
+	} else if !reflectType.IsVariadic() && len(args) != reflectType.NumIn() {
+		panic(fmt.Sprintf("Wrong number of arguments: expected %d, got %d", reflectType.NumIn(), len(args)))
+	}
+	var reflectArgs = make([]reflect.Value, len(args))
+	for j, arg := range args {
+		reflectArgs[j] = reflect.ValueOf(arg)
+		var variadic = reflectType.IsVariadic() && j >= reflectType.NumIn()-1
+		if r, ok := arg.(Range); ok && j < reflectType.NumIn() && !variadic && reflectType.In(j) == reflect.TypeOf([]interface{}{}) {
+			// library functions that take an array get the range's elements
+			reflectArgs[j] = reflect.ValueOf(r.Array())
+		}
+		if arg == nil && variadic {
+			reflectArgs[j] = reflect.Zero(reflectType.In(reflectType.NumIn() - 1).Elem())
+		} else if arg == nil && j < reflectType.NumIn() {
+			reflectArgs[j] = reflect.Zero(reflectType.In(j))
+		}
+		if j < reflectType.NumIn() && !variadic {
+			// library functions that take bytes also take strings and
+			// arrays of bytes, and those that take strings take bytes
+			switch reflectType.In(j) {
+			case reflect.TypeOf([]byte{}):
+				if data, ok := toBytes(arg); ok {
+					reflectArgs[j] = reflect.ValueOf(data)
+				}
+			case reflect.TypeOf(""):
+				if data, ok := arg.([]byte); ok {
+					reflectArgs[j] = reflect.ValueOf(string(data))
 				}
-				panic("Index out of range: " + strconv.Itoa(idx))
-			}
-			panic("Index must be an integer")
-		} else if hash, ok := value.(map[interface{}]interface{}); ok {
-			if _, ok := hash[index]; ok {
-				panic("Cannot decrement hash value")
 			}
-			panic("Key not found: " + index.(string))
 		}
-		// use reflection to index
-		reflectValue := reflect.ValueOf(value)
-		if reflectValue.Kind() == reflect.Array || reflectValue.Kind() == reflect.Slice {
-			if idx, ok := index.(float64); ok {
-				var idx = int(idx)
-				if idx >= 0 && idx < reflectValue.Len() {
-					reflectValue.Index(idx).Set(reflect.ValueOf(reflectValue.Index(idx).Interface().(float64) - by))
-					return
-				}
-				panic("Index out of range: " + strconv.Itoa(idx))
+	}
+	var reflectResult = reflectValue.Call(reflectArgs)
+	switch len(reflectResult) {
+	case 0:
+		return nil
+	case 1:
+		return reflectResult[0].Interface()
+	}
+	var result = make([]interface{}, len(reflectResult))
+	for j, value := range reflectResult {
+		result[j] = value.Interface()
+	}
+	return result
+}
+
+// integer converts a value to an int, accepting ints and floats without a
+// fractional part. It is used for indexes and bitwise operands.
+func integer(value interface{}) (int, bool) {
+	switch value := value.(type) {
+	case int:
+		return value, true
+	case float64:
+		return int(value), value == float64(int(value))
+	}
+	return 0, false
+}
+
+// arithmetic applies a numeric operator. Two ints give an int, except for
+// division, negative powers and results too big for an int; anything else
+// is computed in float64. An object on the left handles the operator with
+// its hook method, such as __add for +.
+func arithmetic(operator TokenType, left interface{}, right interface{}) interface{} {
+	if object, ok := left.(*Object); ok {
+		if result, ok := object.hook(operatorHooks[operator], right); ok {
+			return result
+		}
+		panic(object.Class.Name + " has no " + operatorHooks[operator] + " method")
+	}
+	var x, ok = numeric(left)
+	if !ok {
+		panic("Left operand must be a number")
+	}
+	y, ok := numeric(right)
+	if !ok {
+		panic("Right operand must be a number")
+	}
+	a, leftInt := left.(int)
+	b, rightInt := right.(int)
+	if leftInt && rightInt {
+		// An int result that overflows falls through to the float result.
+		switch operator {
+		case Plus:
+			if c := a + b; (c > a) == (b > 0) {
+				return c
 			}
-			panic("Index must be an integer")
-		} else if reflectValue.Kind() == reflect.Map {
-			var reflectResult = reflectValue.MapIndex(reflect.ValueOf(index))
-			if reflectResult.IsValid() {
-				reflectResult.Set(reflect.ValueOf(reflectResult.Interface().(float64) - by))
-				return
+		case Minus:
+			if c := a - b; (c < a) == (b > 0) {
+				return c
 			}
-			panic("Key not found: " + fmt.Sprintf("%v", index))
-		}
-		panic("Indexing not supported for type: " + fmt.Sprintf("%T", value))
-	case *Member:
-		var value = i.eval(target.Left)
-		if hash, ok := value.(map[interface{}]interface{}); ok {
-			if value, ok := hash[target.Member]; ok {
-				if value, ok := value.(float64); ok {
-					hash[target.Member] = value - by
-					return
+		case Multiply:
+			if c, ok := multiplyInt(a, b); ok {
+				return c
+			}
+		case Modulo:
+			if b == 0 {
+				panic("Division by zero")
+			}
+			return a % b
+		case Power:
+			if b >= 0 {
+				var result, ok = 1, true
+				for ; b > 0 && ok; b >>= 1 {
+					if b&1 == 1 {
+						result, ok = multiplyInt(result, a)
+					}
+					if b > 1 && ok {
+						a, ok = multiplyInt(a, a)
+					}
+				}
+				if ok {
+					return result
 				}
-				panic("Value must be a number")
 			}
-			panic("Key not found: " + target.Member)
 		}
-		panic("Member access not supported for type: " + fmt.Sprintf("%T", value))
-	default:
-		panic("Decrement not supported for type: " + fmt.Sprintf("%T", node.Left))
 	}
+	switch operator {
+	case Plus:
+		return x + y
+	case Minus:
+		return x - y
+	case Multiply:
+		return x * y
+	case Divide:
+		return x / y
+	case Modulo:
+		if y == 0 {
+			panic("Division by zero")
+		}
+		return math.Mod(x, y)
+	case Power:
+		return math.Pow(x, y)
+	}
+	panic("Unknown operator: " + operator.String())
+}
+
+// multiplyInt returns a * b, and false if it overflows an int.
+func multiplyInt(a int, b int) (int, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	var c = a * b
+	if c/b != a || a == -1 && b == math.MinInt || b == -1 && a == math.MinInt {
+		return 0, false
+	}
+	return c, true
+}
+
+// add is + for clam values: a string on the left concatenates, otherwise
+// the operands are added as numbers.
+func add(left interface{}, right interface{}) interface{} {
+	if _, ok := left.(*Object); ok {
+		return arithmetic(Plus, left, right)
+	}
+	if left, ok := left.(string); ok {
+		if right, ok := right.(string); ok {
+			return left + right
+		}
+		return left + stringify(right)
+	}
+	if left, ok := left.([]byte); ok {
+		var right, ok = toBytes(right)
+		if !ok {
+			panic("Right operand must be bytes or a string")
+		}
+		return append(append([]byte{}, left...), right...)
+	}
+	if _, ok := numeric(left); !ok {
+		panic("Left operand must be a string, bytes or a number")
+	}
+	return arithmetic(Plus, left, right)
+}
+
+// bitwise applies an integer operator. Floats are accepted if they hold a
+// whole number; the result is always an int.
+func bitwise(operator TokenType, left interface{}, right interface{}) interface{} {
+	var a, ok = integer(left)
+	if !ok {
+		panic("Left operand must be an integer")
+	}
+	b, ok := integer(right)
+	if !ok {
+		panic("Right operand must be an integer")
+	}
+	switch operator {
+	case BitAnd:
+		return a & b
+	case BitOr:
+		return a | b
+	case BitXor:
+		return a ^ b
+	case ShiftLeft, ShiftRight:
+		if b < 0 {
+			panic("Negative shift count: " + strconv.Itoa(b))
+		}
+		if operator == ShiftLeft {
+			return a << b
+		}
+		return a >> b
+	}
+	panic("Unknown operator: " + operator.String())
+}
+
+// compare applies an ordering operator. Objects with a __lt method define
+// their own order, and the other operators are derived from it.
+func compare(operator TokenType, left interface{}, right interface{}) bool {
+	switch operator {
+	case Less:
+		return less(left, right)
+	case Greater:
+		return less(right, left)
+	case LessEqual:
+		return !less(right, left)
+	}
+	return !less(left, right)
+}
+
+// stringify converts a value to the text used when it is joined to a
+// string.
+func stringify(value interface{}) string {
+	if data, ok := value.([]byte); ok {
+		return string(data)
+	}
+	return fmt.Sprint(value)
 }
 
 func truthy(value interface{}) bool {
@@ -455,6 +1085,28 @@ func (i *Interpreter) eval(expr Expression) interface{} {
 		return expr.Value
 	case *StringLiteral:
 		return expr.Value
+	case *BytesLiteral:
+		return append([]byte{}, expr.Value...)
+	case *BranchExpression:
+		var outer = i.branchValue
+		i.branchValue = nil
+		i.exec(expr.Statement)
+		var result = i.branchValue
+		i.branchValue = outer
+		return result
+	case *Interpolation:
+		var pos = i.Pos
+		var result strings.Builder
+		for j, part := range expr.Parts {
+			if literal, ok := part.(*StringLiteral); ok {
+				result.WriteString(literal.Value)
+				continue
+			}
+			i.Pos = expr.Starts[j]
+			result.WriteString(stringify(i.eval(part)))
+		}
+		i.Pos = pos
+		return result.String()
 	case *BooleanLiteral:
 		return expr.Value
 	case *NilLiteral:
@@ -465,201 +1117,84 @@ func (i *Interpreter) eval(expr Expression) interface{} {
 			result[j] = i.eval(value)
 		}
 		return result
+	case *SetLiteral:
+		var result = NewSet()
+		for _, value := range expr.Values {
+			result.Add(i.eval(value))
+		}
+		return result
 	case *HashLiteral:
-		var result = make(map[interface{}]interface{})
-		for key, value := range expr.Pairs {
-			result[i.eval(key)] = i.eval(value)
+		var result = NewHash()
+		for _, pair := range expr.Pairs {
+			result.Set(i.eval(pair.Key), i.eval(pair.Value))
 		}
 		return result
 	case *Variable:
-		var scope = len(i.Variables) - 1
-		for scope >= 0 {
-			if value, ok := i.Variables[scope][expr.Name]; ok {
-				return value
-			}
-			scope--
+		if value, ok := i.lookup(expr.Name); ok {
+			return value
 		}
 		panic("Undefined variable: " + expr.Name)
-	case *Index:
-		var value = i.eval(expr.Left)
-		var index = i.eval(expr.Index)
-		if array, ok := value.([]interface{}); ok {
-			if idx, ok := index.(float64); ok {
-				var idx = int(idx)
-				if idx >= 0 && idx < len(array) {
-					return array[idx]
-				}
-				panic("Index out of range: " + strconv.Itoa(idx))
-			}
-			panic("Index must be an integer")
-		} else if hash, ok := value.(map[interface{}]interface{}); ok {
-			if result, ok := hash[index]; ok {
-				return result
-			}
-			panic("Key not found: " + index.(string))
-		} else {
-			// use reflection to index
-			reflectValue := reflect.ValueOf(value)
-			if reflectValue.Kind() == reflect.Array || reflectValue.Kind() == reflect.Slice {
-				if idx, ok := index.(float64); ok {
-					var idx = int(idx)
-					if idx >= 0 && idx < reflectValue.Len() {
-						return reflectValue.Index(idx).Interface()
-					}
-					panic("Index out of range: " + strconv.Itoa(idx))
-				}
-				panic("Index must be an integer")
-			} else if reflectValue.Kind() == reflect.Map {
-				var reflectResult = reflectValue.MapIndex(reflect.ValueOf(index))
-				if reflectResult.IsValid() {
-					return reflectResult.Interface()
-				}
-				panic("Key not found: " + fmt.Sprintf("%v", index))
-			}
-		}
-	case *Call:
-		var function = i.eval(expr.Function)
-		var args = make([]interface{}, len(expr.Args))
-		for j, arg := range expr.Args {
-			args[j] = i.eval(arg)
-		}
-		if anyFn, ok := function.(func(...interface{}) interface{}); ok {
-			return anyFn(args...)
-		} else {
-			// use go's reflection to call method
-			reflectValue := reflect.ValueOf(function)
-			if reflectValue.Kind() == reflect.Func {
-				var reflectArgs = make([]reflect.Value, len(args))
-				for j, arg := range args {
-					reflectArgs[j] = reflect.ValueOf(arg)
-				}
-				var reflectResult = reflectValue.Call(reflectArgs)
-				if len(reflectResult) == 1 {
-					return reflectResult[0].Interface()
-				}
-				var result = make([]interface{}, len(reflectResult))
-				for j, value := range reflectResult {
-					result[j] = value.Interface()
-				}
-				return result
-			}
-			panic("Not a function: " + fmt.Sprintf("%T", function))
-		}
-	case *Member:
-		var value = i.eval(expr.Left)
-		if hash, ok := value.(map[interface{}]interface{}); ok {
-			if result, ok := hash[expr.Member]; ok {
-				return result
-			}
-			panic("Key not found: " + expr.Member)
+	case *Index, *Member, *Call:
+		var value, _ = i.link(expr)
+		return value
+	case *RangeExpression:
+		var step interface{} = 1
+		if expr.Step != nil {
+			step = i.eval(expr.Step)
 		}
-		panic("Member access not supported for type: " + fmt.Sprintf("%T", value))
+		return NewRange(i.eval(expr.Start), i.eval(expr.End), step, expr.Exclusive)
+	case *SpawnExpression:
+		var function = i.eval(expr.Call.Function)
+		return i.spawn(function, i.evalArgs(expr.Call.Args))
 	case *Unary:
 		var value = i.eval(expr.Right)
 		switch expr.Operator {
 		case Not:
 			return !truthy(value)
 		case Minus:
-			if value, ok := value.(float64); ok {
+			switch value := value.(type) {
+			case int:
 				return -value
+			case float64:
+				return -value
+			case *Object:
+				if result, ok := value.hook("__neg"); ok {
+					return result
+				}
 			}
+		case BitNot:
+			if value, ok := integer(value); ok {
+				return ^value
+			}
+			panic("Operand must be an integer")
 		}
 		panic("Unary operator not supported for type: " + fmt.Sprintf("%T", value))
 	case *Binary:
 		var left = i.eval(expr.Left)
 		switch expr.Operator {
 		case Plus:
-			var right = i.eval(expr.Right)
-			if left, ok := left.(string); ok {
-				if right, ok := right.(string); ok {
-					return left + right
-				}
-				return left + fmt.Sprintf("%v", right)
-			}
-			if left, ok := left.(float64); ok {
-				if right, ok := right.(float64); ok {
-					return left + right
-				}
-				panic("Right operand must be a number")
-			}
-			panic("Left operand must be a string or a number")
-		case Minus:
-			var right = i.eval(expr.Right)
-			if left, ok := left.(float64); ok {
-				if right, ok := right.(float64); ok {
-					return left - right
-				}
-				panic("Right operand must be a number")
-			}
-			panic("Left operand must be a number")
-		case Multiply:
-			var right = i.eval(expr.Right)
-			if left, ok := left.(float64); ok {
-				if right, ok := right.(float64); ok {
-					return left * right
-				}
-				panic("Right operand must be a number")
-			}
-			panic("Left operand must be a number")
-		case Divide:
-			var right = i.eval(expr.Right)
-			if left, ok := left.(float64); ok {
-				if right, ok := right.(float64); ok {
-					return left / right
-				}
-				panic("Right operand must be a number")
-			}
-			panic("Left operand must be a number")
-		case Modulo:
-			var right = i.eval(expr.Right)
-			if left, ok := left.(float64); ok {
-				if right, ok := right.(float64); ok {
-					return float64(int(left) % int(right))
-				}
-				panic("Right operand must be a number")
-			}
+			return add(left, i.eval(expr.Right))
+		case Minus, Multiply, Divide, Modulo, Power:
+			return arithmetic(expr.Operator, left, i.eval(expr.Right))
+		case BitAnd, BitOr, BitXor, ShiftLeft, ShiftRight:
+			return bitwise(expr.Operator, left, i.eval(expr.Right))
 		case Equal:
 			var right = i.eval(expr.Right)
-			return left == right
+			return equal(left, right)
 		case NotEqual:
 			var right = i.eval(expr.Right)
-			return left != right
-		case Less:
-			var right = i.eval(expr.Right)
-			if left, ok := left.(float64); ok {
-				if right, ok := right.(float64); ok {
-					return left < right
-				}
-				panic("Right operand must be a number")
-			}
-			panic("Left operand must be a number")
-		case LessEqual:
-			var right = i.eval(expr.Right)
-			if left, ok := left.(float64); ok {
-				if right, ok := right.(float64); ok {
-					return left <= right
-				}
-				panic("Right operand must be a number")
-			}
-			panic("Left operand must be a number")
-		case Greater:
-			var right = i.eval(expr.Right)
-			if left, ok := left.(float64); ok {
-				if right, ok := right.(float64); ok {
-					return left > right
-				}
-				panic("Right operand must be a number")
-			}
-			panic("Left operand must be a number")
-		case GreaterEqual:
-			var right = i.eval(expr.Right)
-			if left, ok := left.(float64); ok {
-				if right, ok := right.(float64); ok {
-					return left >= right
-				}
-				panic("Right operand must be a number")
+			return !equal(left, right)
+		case Less, LessEqual, Greater, GreaterEqual:
+			return compare(expr.Operator, left, i.eval(expr.Right))
+		case Coalesce:
+			if left != nil {
+				return left
 			}
-			panic("Left operand must be a number")
+			return i.eval(expr.Right)
+		case In:
+			return contains(i.eval(expr.Right), left)
+		case NotIn:
+			return !contains(i.eval(expr.Right), left)
 		case And:
 			if truthy(left) {
 				return i.eval(expr.Right)
@@ -686,12 +1221,10 @@ func (i *Interpreter) eval(expr Expression) interface{} {
 	case *FunctionLiteral:
 		return func(args ...interface{}) (v interface{}) {
 			var prev = i.Variables
+			var pos = i.Pos
 			i.Variables = make([]map[string]interface{}, 2)
 			i.Variables[0] = prev[0]
 			i.Variables[1] = make(map[string]interface{})
-			for j, arg := range expr.Params {
-				i.Variables[1][arg] = args[j]
-			}
 			defer func() {
 				i.Variables = prev
 			}()
@@ -700,21 +1233,134 @@ func (i *Interpreter) eval(expr Expression) interface{} {
 				if r := recover(); r != nil {
 					if returnValue, ok := r.(ReturnValue); ok {
 						v = returnValue.Value
+					} else {
+						panic(r)
 					}
-					panic(r)
 				}
+				i.Pos = pos
 			}()
+			i.bind("sub", expr.Params, args, i.Variables[1])
 			for _, s := range expr.Body {
 				i.exec(s)
 			}
 			return nil
 		}
 	case *Increment:
-		i.inc(expr)
-		return i.eval(expr.Left)
+		return i.inc(expr)
 	case *Decrement:
-		i.dec(expr)
-		return i.eval(expr.Left)
+		return i.dec(expr)
+	case *Assignment:
+		return i.assign(expr.Left, expr.Operator, expr.Value)
 	}
 	return nil
 }
+
+// link evaluates a member access, index or call, the links of a chain such
+// as a?.b.c(). It returns false when an optional link found nil, which
+// makes the rest of the chain nil too, without evaluating it.
+func (i *Interpreter) link(expr Expression) (interface{}, bool) {
+	switch expr := expr.(type) {
+	case *Index:
+		var value, ok = i.link(expr.Left)
+		if !ok || expr.Optional && value == nil {
+			return nil, false
+		}
+		var index = i.eval(expr.Index)
+		if _, ok := value.(*Object); expr.Optional && !ok && !hasKey(value, index) {
+			return nil, true
+		}
+		if array, ok := value.([]interface{}); ok {
+			if idx, ok := integer(index); ok {
+				if idx >= 0 && idx < len(array) {
+					return array[idx], true
+				}
+				panic("Index out of range: " + strconv.Itoa(idx))
+			}
+			panic("Index must be an integer")
+		} else if object, ok := value.(*Object); ok {
+			if result, ok := object.hook("__index", index); ok {
+				return result, true
+			}
+			panic(object.Class.Name + " has no __index method")
+		} else if r, ok := value.(Range); ok {
+			if idx, ok := integer(index); ok {
+				if idx >= 0 && idx < r.Len() {
+					return r.At(idx), true
+				}
+				panic("Index out of range: " + strconv.Itoa(idx))
+			}
+			panic("Index must be an integer")
+		} else if str, ok := value.(string); ok {
+			if idx, ok := integer(index); ok {
+				var runes = []rune(str)
+				if idx >= 0 && idx < len(runes) {
+					return string(runes[idx]), true
+				}
+				panic("Index out of range: " + strconv.Itoa(idx))
+			}
+			panic("Index must be an integer")
+		} else if data, ok := value.([]byte); ok {
+			if idx, ok := integer(index); ok {
+				if idx >= 0 && idx < len(data) {
+					return int(data[idx]), true
+				}
+				panic("Index out of range: " + strconv.Itoa(idx))
+			}
+			panic("Index must be an integer")
+		} else if hash, ok := value.(*Hash); ok {
+			if result, ok := hash.Get(index); ok {
+				return result, true
+			}
+			panic("Key not found: " + stringify(index))
+		} else {
+			// use reflection to index
+			reflectValue := reflect.ValueOf(value)
+			if reflectValue.Kind() == reflect.Array || reflectValue.Kind() == reflect.Slice {
+				if idx, ok := index.(float64); ok {
+					var idx = int(idx)
+					if idx >= 0 && idx < reflectValue.Len() {
+						return reflectValue.Index(idx).Interface(), true
+					}
+					panic("Index out of range: " + strconv.Itoa(idx))
+				}
+				panic("Index must be an integer")
+			} else if reflectValue.Kind() == reflect.Map {
+				var reflectResult = reflectValue.MapIndex(reflect.ValueOf(index))
+				if reflectResult.IsValid() {
+					return reflectResult.Interface(), true
+				}
+				panic("Key not found: " + fmt.Sprintf("%v", index))
+			}
+		}
+		return nil, true
+	case *Call:
+		var function, ok = i.link(expr.Function)
+		if !ok {
+			return nil, false
+		}
+		return callFunction(function, i.evalArgs(expr.Args)), true
+	case *Member:
+		var value, ok = i.link(expr.Left)
+		if !ok || expr.Optional && value == nil {
+			return nil, false
+		}
+		if hash, ok := value.(*Hash); ok {
+			if result, ok := hash.Get(expr.Member); ok || expr.Optional {
+				return result, true
+			}
+			panic("Key not found: " + expr.Member)
+		} else if object, ok := value.(*Object); ok {
+			if result, ok := object.member(expr.Member); ok || expr.Optional {
+				return result, true
+			}
+			panic(object.Class.Name + " has no member " + expr.Member)
+		} else if super, ok := value.(*Super); ok {
+			if result, ok := super.member(expr.Member); ok {
+				return result, true
+			}
+			panic("No parent method " + expr.Member)
+		}
+		panic("Member access not supported for type: " + fmt.Sprintf("%T", value))
+	}
+	return i.eval(expr), true
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"io"
+)
+
+// Iterator is implemented by native values that a for loop can iterate
+// over. Next returns the next element, or false when there are no more.
+//
+// Clam code can also write an iterator as a hash with a next sub, which
+// returns nil when it is done.
+type Iterator interface {
+	Next() (interface{}, bool)
+}
+
+// IteratorFunc adapts a function to the Iterator interface.
+type IteratorFunc func() (interface{}, bool)
+
+func (f IteratorFunc) Next() (interface{}, bool) {
+	return f()
+}
+
+// iterator returns value as an Iterator if it is a native iterator or a
+// hash with a next sub.
+func iterator(value interface{}) (Iterator, bool) {
+	switch value := value.(type) {
+	case Iterator:
+		return value, true
+	case *Hash:
+		var next, ok = value.Get("next")
+		if !ok || !hasType(next, "sub") {
+			return nil, false
+		}
+		return IteratorFunc(func() (interface{}, bool) {
+			var element = callFunction(next, nil)
+			return element, element != nil
+		}), true
+	}
+	return nil, false
+}
+
+// linesIterator iterates over the lines of r, without their line endings.
+// It stops at the end of the input or at the first read error.
+func linesIterator(r io.Reader) Iterator {
+	var scanner = bufio.NewScanner(r)
+	return IteratorFunc(func() (interface{}, bool) {
+		if !scanner.Scan() {
+			return nil, false
+		}
+		return scanner.Text(), true
+	})
+}
+
+// chunksIterator iterates over the data read from r, in byte arrays of at
+// most n bytes.
+func chunksIterator(r io.Reader, n int) Iterator {
+	var done bool
+	return IteratorFunc(func() (interface{}, bool) {
+		for !done {
+			var buffer = make([]byte, n)
+			var count, err = r.Read(buffer)
+			done = err != nil
+			if count > 0 {
+				return buffer[:count], true
+			}
+		}
+		return nil, false
+	})
+}
+
+// Generator is the iterator returned by calling a sub that yields. The body
+// runs on its own goroutine, but only while the loop consuming it waits for
+// the next value, so the interpreter's state is never shared. Each side
+// swaps in its own scopes when it gets control. The goroutine starts with
+// the first call to Next, and Close ends it if the consumer stops early.
+type Generator struct {
+	interpreter *Interpreter
+	scope       []map[string]interface{}
+	body        []Statement
+	started     bool
+	resume      chan struct{}
+	stop        chan struct{}
+	results     chan generated
+	done        bool
+
+	// the consumer's state, saved while the generator runs
+	variables []map[string]interface{}
+	generator *Generator
+}
+
+type generated struct {
+	value interface{}
+	// failure is the panic the body raised, if any
+	failure interface{}
+	done    bool
+}
+
+// generatorClosed is raised in the body of a generator to unwind it when
+// the generator is closed.
+type generatorClosed struct{}
+
+func newGenerator(i *Interpreter, variables []map[string]interface{}, body []Statement) *Generator {
+	return &Generator{interpreter: i, scope: variables, body: body, resume: make(chan struct{}), stop: make(chan struct{}), results: make(chan generated)}
+}
+
+// run executes the body on the generator's goroutine.
+func (g *Generator) run() {
+	var i = g.interpreter
+	var result generated
+	defer func() {
+		if r := recover(); r != nil {
+			switch r.(type) {
+			case ReturnValue, generatorClosed:
+			default:
+				result.failure = r
+			}
+		}
+		i.Variables, i.generator = g.variables, g.generator
+		result.done = true
+		g.results <- result
+	}()
+	i.Variables, i.generator = g.scope, g
+	for _, s := range g.body {
+		i.exec(s)
+	}
+}
+
+// Next runs the generator until it yields a value or finishes. A panic in
+// the body is raised again in the consumer.
+func (g *Generator) Next() (interface{}, bool) {
+	if g.done {
+		return nil, false
+	}
+	var i = g.interpreter
+	g.variables, g.generator = i.Variables, i.generator
+	var pos = i.Pos
+	if g.started {
+		g.resume <- struct{}{}
+	} else {
+		g.started = true
+		go g.run()
+	}
+	var result = <-g.results
+	if result.failure != nil {
+		g.done = true
+		panic(result.failure)
+	}
+	if result.done {
+		g.done = true
+		i.Pos = pos
+		return nil, false
+	}
+	i.Pos = pos
+	return result.value, true
+}
+
+// Close stops a generator that has not finished, unwinding its body so that
+// its goroutine ends. It is called when a loop over the generator exits.
+func (g *Generator) Close() {
+	if g.done {
+		return
+	}
+	g.done = true
+	if !g.started {
+		return
+	}
+	var i = g.interpreter
+	g.variables, g.generator = i.Variables, i.generator
+	var pos = i.Pos
+	close(g.stop)
+	<-g.results
+	i.Pos = pos
+}
+
+// yield hands value to the consumer and waits until it wants the next one.
+func (g *Generator) yield(value interface{}) {
+	var i = g.interpreter
+	var variables, pos = i.Variables, i.Pos
+	select {
+	case <-g.stop:
+		panic(generatorClosed{})
+	default:
+	}
+	i.Variables, i.generator = g.variables, g.generator
+	g.results <- generated{value: value}
+	select {
+	case <-g.resume:
+		i.Variables, i.Pos, i.generator = variables, pos, g
+	case <-g.stop:
+		i.Variables, i.Pos, i.generator = variables, pos, g
+		panic(generatorClosed{})
+	}
+}
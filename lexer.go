@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType uint8
@@ -12,13 +15,16 @@ const (
 	Id TokenType = iota
 	Number
 	String
+	Bytes
 	True
 	False
 	Nil
 
 	// Keywords
 	My
+	Const
 	Sub
+	Class
 	When
 	Case
 	If
@@ -30,6 +36,8 @@ const (
 	Until
 	Do
 	Return
+	Yield
+	Spawn
 	Inc
 	Dec
 	By
@@ -40,8 +48,16 @@ const (
 	Multiply
 	Divide
 	Modulo
+	Power
+	BitAnd
+	BitOr
+	BitXor
+	ShiftLeft
+	ShiftRight
+	BitNot
 	And
 	Or
+	Coalesce
 	Not
 	Equal
 	NotEqual
@@ -49,7 +65,14 @@ const (
 	LessEqual
 	Greater
 	GreaterEqual
+	NotIn
 	Assign
+	PlusAssign
+	MinusAssign
+	MultiplyAssign
+	DivideAssign
+	ModuloAssign
+	AppendAssign
 
 	// Punctuation
 	Comma
@@ -61,7 +84,12 @@ const (
 	RightBrace
 	LeftBracket
 	RightBracket
+	SetBracket
 	Dot
+	OptionalDot
+	OptionalBracket
+	DotDot
+	Ellipsis
 
 	// Special
 	Eof
@@ -69,7 +97,9 @@ const (
 
 var keywords = map[string]TokenType{
 	"my":     My,
+	"const":  Const,
 	"sub":    Sub,
+	"class":  Class,
 	"when":   When,
 	"case":   Case,
 	"if":     If,
@@ -81,12 +111,20 @@ var keywords = map[string]TokenType{
 	"until":  Until,
 	"do":     Do,
 	"return": Return,
+	"yield":  Yield,
+	"spawn":  Spawn,
 	"true":   True,
 	"false":  False,
 	"nil":    Nil,
 	"inc":    Inc,
 	"dec":    Dec,
 	"by":     By,
+	"band":   BitAnd,
+	"bor":    BitOr,
+	"bxor":   BitXor,
+	"bnot":   BitNot,
+	"shl":    ShiftLeft,
+	"shr":    ShiftRight,
 }
 
 type Token struct {
@@ -94,6 +132,20 @@ type Token struct {
 	Literal string
 	Line    int
 	Column  int
+	// Doc holds the ## comment lines directly above the token, if any.
+	Doc string
+	// Parts is set instead of Literal for strings with embedded expressions.
+	Parts []StringPart
+}
+
+// StringPart is either literal text of an interpolated string, or the source
+// of an embedded expression. Line and Column give where the part starts.
+type StringPart struct {
+	Text   string
+	Expr   bool
+	Source string
+	Line   int
+	Column int
 }
 
 func (t Token) String() string {
@@ -106,27 +158,84 @@ type Lexer struct {
 
 	line   int
 	column int
+	// width is the size in bytes of the last code point read
+	width int
+
+	// Doc is the ## comment block at the top of the input, when it is not
+	// attached to the first token.
+	Doc     string
+	doc     string
+	docLine int
+	started bool
+
+	// margin is the column that lines start at, which is the stripped
+	// indentation when lexing the body of a <<~ heredoc
+	margin int
+
+	// heredoc bodies follow the line their operator is on; when the lexer
+	// reaches the newline at skipFrom it jumps to skipTo, skipping skipLines
+	heredoc   bool
+	skipFrom  int
+	skipTo    int
+	skipLines int
 }
 
 func NewLexer(input string) *Lexer {
 	return &Lexer{input: input}
 }
 
-func (l *Lexer) peekChar() byte {
+// newLexerAt creates a lexer for a fragment of a larger input that starts at
+// the given line and column, so that tokens keep their original positions.
+func newLexerAt(input string, line int, column int) *Lexer {
+	return &Lexer{input: input, line: line, column: column, started: true}
+}
+
+func (l *Lexer) peekChar() rune {
+	if l.position >= len(l.input) {
+		return 0
+	}
+	var ch, _ = utf8.DecodeRuneInString(l.input[l.position:])
+	return ch
+}
+
+func (l *Lexer) peekNext() rune {
 	if l.position >= len(l.input) {
 		return 0
 	}
-	return l.input[l.position]
+	var _, size = utf8.DecodeRuneInString(l.input[l.position:])
+	if l.position+size >= len(l.input) {
+		return 0
+	}
+	var ch, _ = utf8.DecodeRuneInString(l.input[l.position+size:])
+	return ch
+}
+
+func (l *Lexer) newline() {
+	l.line++
+	l.column = l.margin
 }
 
-func (l *Lexer) readChar() byte {
-	var ch = l.peekChar()
-	l.position++
+// readChar reads the next code point. Columns count code points, not bytes.
+func (l *Lexer) readChar() rune {
+	if l.position >= len(l.input) {
+		l.position++
+		l.column++
+		return 0
+	}
+	var ch, size = utf8.DecodeRuneInString(l.input[l.position:])
+	l.position += size
 	l.column++
+	l.width = size
 	return ch
 }
 
-func (l *Lexer) matchChar(ch byte) bool {
+// unreadChar steps back over the code point returned by the last readChar.
+func (l *Lexer) unreadChar() {
+	l.position -= l.width
+	l.column--
+}
+
+func (l *Lexer) matchChar(ch rune) bool {
 	if l.peekChar() == ch {
 		l.readChar()
 		return true
@@ -135,26 +244,92 @@ func (l *Lexer) matchChar(ch byte) bool {
 }
 
 func (l *Lexer) NextToken() Token {
+	var token = l.nextToken()
+	if l.doc != "" {
+		if token.Line == l.docLine+1 {
+			token.Doc = strings.TrimSuffix(l.doc, "\n")
+			l.doc = ""
+		} else {
+			l.flushDoc()
+		}
+	}
+	l.started = true
+	return token
+}
+
+// comment collects consecutive ## lines into a single doc comment.
+func (l *Lexer) comment(line int, text string) {
+	if l.doc != "" && line != l.docLine+1 {
+		l.flushDoc()
+	}
+	l.doc += text + "\n"
+	l.docLine = line
+}
+
+// flushDoc drops a doc comment that isn't attached to a token, keeping it as
+// the file's doc comment if no token has been read yet.
+func (l *Lexer) flushDoc() {
+	if !l.started && l.Doc == "" {
+		l.Doc = strings.TrimSuffix(l.doc, "\n")
+	}
+	l.doc = ""
+}
+
+func (l *Lexer) nextToken() Token {
 	var line = l.line
 	var column = l.column
 	var ch = l.readChar()
 	switch ch {
 	case '#':
-		for l.peekChar() != '\n' {
-			notAtEnd(l.readChar())
+		var start = l.position
+		for l.peekChar() != '\n' && l.peekChar() != 0 {
+			l.readChar()
 		}
-		l.line++
-		l.column = 0
-		return l.NextToken()
+		if text, ok := strings.CutPrefix(l.input[start:l.position], "#"); ok {
+			l.comment(line, strings.TrimPrefix(text, " "))
+		} else if l.doc != "" {
+			l.flushDoc()
+		}
+		return l.nextToken()
 	case '+':
+		if l.matchChar('=') {
+			return Token{Type: PlusAssign, Literal: "+=", Line: line, Column: column}
+		}
 		return Token{Type: Plus, Literal: "+", Line: line, Column: column}
 	case '-':
+		if l.matchChar('=') {
+			return Token{Type: MinusAssign, Literal: "-=", Line: line, Column: column}
+		}
 		return Token{Type: Minus, Literal: "-", Line: line, Column: column}
 	case '*':
+		if l.matchChar('*') {
+			return Token{Type: Power, Literal: "**", Line: line, Column: column}
+		}
+		if l.matchChar('=') {
+			return Token{Type: MultiplyAssign, Literal: "*=", Line: line, Column: column}
+		}
 		return Token{Type: Multiply, Literal: "*", Line: line, Column: column}
 	case '/':
+		if l.matchChar('=') {
+			return Token{Type: DivideAssign, Literal: "/=", Line: line, Column: column}
+		}
 		return Token{Type: Divide, Literal: "/", Line: line, Column: column}
+	case '?':
+		if l.matchChar('?') {
+			return Token{Type: Coalesce, Literal: "??", Line: line, Column: column}
+		} else if l.matchChar('.') {
+			return Token{Type: OptionalDot, Literal: "?.", Line: line, Column: column}
+		} else if l.matchChar('[') {
+			return Token{Type: OptionalBracket, Literal: "?[", Line: line, Column: column}
+		}
+		panic("Unknown token type: " + string(ch) + " (" + strconv.Itoa(l.line) + ":" + strconv.Itoa(l.column) + ")")
 	case '%':
+		if l.matchChar('=') {
+			return Token{Type: ModuloAssign, Literal: "%=", Line: line, Column: column}
+		}
+		if l.matchChar('[') {
+			return Token{Type: SetBracket, Literal: "%[", Line: line, Column: column}
+		}
 		return Token{Type: Modulo, Literal: "%", Line: line, Column: column}
 	case '(':
 		return Token{Type: LeftParen, Literal: "(", Line: line, Column: column}
@@ -175,6 +350,19 @@ func (l *Lexer) NextToken() Token {
 	case ';':
 		return Token{Type: Semicolon, Literal: ";", Line: line, Column: column}
 	case '.':
+		if l.matchChar('=') {
+			return Token{Type: AppendAssign, Literal: ".=", Line: line, Column: column}
+		}
+		if l.matchChar('.') {
+			if l.matchChar('.') {
+				return Token{Type: Ellipsis, Literal: "...", Line: line, Column: column}
+			}
+			return Token{Type: DotDot, Literal: "..", Line: line, Column: column}
+		}
+		if isDigit(l.peekChar()) {
+			l.unreadChar()
+			return l.readNumber()
+		}
 		return Token{Type: Dot, Literal: ".", Line: line, Column: column}
 	case '=':
 		if l.matchChar('=') {
@@ -182,6 +370,10 @@ func (l *Lexer) NextToken() Token {
 		}
 		return Token{Type: Assign, Literal: "=", Line: line, Column: column}
 	case '<':
+		if l.peekChar() == '<' && (isLetter(l.peekNext()) || strings.ContainsRune("~\"'", l.peekNext())) {
+			l.readChar()
+			return l.readHeredoc(line, column)
+		}
 		if l.matchChar('=') {
 			return Token{Type: LessEqual, Literal: "<=", Line: line, Column: column}
 		}
@@ -203,32 +395,38 @@ func (l *Lexer) NextToken() Token {
 	case '"':
 		fallthrough
 	case '\'':
-		return Token{Type: String, Literal: l.readString(ch), Line: line, Column: column}
+		return l.readString(ch, line, column)
+	case '`':
+		return l.readRaw(line, column)
 	case ' ', '\t', '\r':
-		return l.NextToken()
+		return l.nextToken()
 	case '\n':
-		l.line++
-		l.column = 0
-		return l.NextToken()
+		l.newline()
+		if l.heredoc && l.position-1 == l.skipFrom {
+			l.position = l.skipTo
+			l.line += l.skipLines
+			l.heredoc = false
+			l.skipLines = 0
+		}
+		return l.nextToken()
 	case 0:
 		return Token{Type: Eof, Literal: "", Line: line, Column: column}
 	default:
-		if isLetter(ch) {
-			l.position--
-			l.column--
+		if ch == 'b' && (l.peekChar() == '"' || l.peekChar() == '\'') {
+			return l.readBytes(line, column)
+		} else if isLetter(ch) {
+			l.unreadChar()
 			return l.readIdentifier()
 		} else if isDigit(ch) {
-			l.position--
-			l.column--
+			l.unreadChar()
 			return l.readNumber()
 		} else {
 			panic("Unknown token type: " + string(ch) + " (" + strconv.Itoa(l.line) + ":" + strconv.Itoa(l.column) + ")")
 		}
 	}
-	return Token{}
 }
 
-func notAtEnd(ch byte) byte {
+func notAtEnd(ch rune) rune {
 	if ch == 0 {
 		panic("Unexpected end of file")
 	}
@@ -239,57 +437,373 @@ func (l *Lexer) readIdentifier() Token {
 	var position = l.position
 	var line = l.line
 	var column = l.column
-	for isLetter(l.peekChar()) || isDigit(l.peekChar()) {
+	for isIdentifierPart(l.peekChar()) {
 		notAtEnd(l.readChar())
 	}
 	if tokenType, ok := keywords[l.input[position:l.position]]; ok {
 		return Token{Type: tokenType, Literal: l.input[position:l.position], Line: line, Column: column}
 	}
+	if l.input[position:l.position] == "not" {
+		// not is only a keyword in the operator not in
+		var rest = strings.TrimLeft(l.input[l.position:], " \t")
+		if after, ok := strings.CutPrefix(rest, "in"); ok {
+			if next, _ := utf8.DecodeRuneInString(after); after == "" || !isIdentifierPart(next) {
+				for l.peekChar() != 'i' {
+					l.readChar()
+				}
+				l.readChar()
+				l.readChar()
+				return Token{Type: NotIn, Literal: "not in", Line: line, Column: column}
+			}
+		}
+	}
 	return Token{Type: Id, Literal: l.input[position:l.position], Line: line, Column: column}
 }
 
+// readNumber reads a numeric literal: decimal with an optional fraction and
+// exponent, or hexadecimal, binary or octal after a 0x, 0b or 0o prefix.
+// Underscores may separate digits. The literal keeps its source text; the
+// parser converts it with parseNumber.
 func (l *Lexer) readNumber() Token {
 	var position = l.position
 	var line = l.line
 	var column = l.column
-	for isDigit(l.peekChar()) {
-		notAtEnd(l.readChar())
+	var kind = "number"
+	if l.peekChar() == '0' && strings.ContainsRune("xXbBoO", l.peekNext()) {
+		l.readChar()
+		switch l.readChar() {
+		case 'x', 'X':
+			kind = "hexadecimal"
+			l.readDigits(isHexDigit, position, line, column, kind)
+		case 'b', 'B':
+			kind = "binary"
+			l.readDigits(func(ch rune) bool { return ch == '0' || ch == '1' }, position, line, column, kind)
+		default:
+			kind = "octal"
+			l.readDigits(func(ch rune) bool { return '0' <= ch && ch <= '7' }, position, line, column, kind)
+		}
+	} else {
+		if l.peekChar() != '.' {
+			l.readDigits(isDigit, position, line, column, kind)
+		}
+		if l.peekChar() == '.' && isDigit(l.peekNext()) {
+			l.readChar()
+			l.readDigits(isDigit, position, line, column, kind)
+		}
+		if l.peekChar() == 'e' || l.peekChar() == 'E' {
+			l.readChar()
+			if l.peekChar() == '+' || l.peekChar() == '-' {
+				l.readChar()
+			}
+			l.readDigits(isDigit, position, line, column, kind)
+		}
 	}
-	if l.peekChar() == '.' {
-		notAtEnd(l.readChar())
-		for isDigit(l.peekChar()) {
-			notAtEnd(l.readChar())
+	if isIdentifierPart(l.peekChar()) {
+		for isIdentifierPart(l.peekChar()) {
+			l.readChar()
 		}
+		panic(fmt.Sprintf("Invalid %s literal %s (%d:%d)", kind, l.input[position:l.position], line, column))
 	}
 	return Token{Type: Number, Literal: l.input[position:l.position], Line: line, Column: column}
 }
 
-func (l *Lexer) readString(ch byte) string {
+// readDigits reads a run of digits accepted by valid, allowing single
+// underscores between them. Anything else that would continue the literal,
+// such as a digit out of range for the base, is an error reported at the
+// start of the literal.
+func (l *Lexer) readDigits(valid func(rune) bool, position int, line int, column int, kind string) {
+	var invalid = func() {
+		for isIdentifierPart(l.peekChar()) {
+			l.readChar()
+		}
+		panic(fmt.Sprintf("Invalid %s literal %s (%d:%d)", kind, l.input[position:l.position], line, column))
+	}
+	if !valid(l.peekChar()) {
+		invalid()
+	}
+	for valid(l.peekChar()) {
+		l.readChar()
+		if l.peekChar() == '_' {
+			l.readChar()
+			if !valid(l.peekChar()) {
+				invalid()
+			}
+		}
+	}
+}
+
+// readString reads a quoted string up to the closing quote, decoding escape
+// sequences. Double-quoted strings may embed expressions as ${...}; the token
+// then carries the text and expression source in Parts instead of a Literal.
+func (l *Lexer) readString(quote rune, line int, column int) Token {
+	var parts = l.scanString(quote, quote == '"')
+	l.readChar()
+	return stringToken(parts, line, column)
+}
+
+// readBytes reads a bytes literal, b"..." or b'...'. It takes the same
+// escapes as a string, with \xHH giving any byte, but no interpolation.
+func (l *Lexer) readBytes(line int, column int) Token {
+	var quote = l.readChar()
+	var parts = l.scanString(quote, false)
+	l.readChar()
+	return Token{Type: Bytes, Literal: parts[0].Text, Line: line, Column: column}
+}
+
+// readRaw reads a backtick string, which has no escapes or interpolation
+// and may span lines.
+func (l *Lexer) readRaw(line int, column int) Token {
 	var position = l.position
-	for l.peekChar() != ch {
-		var ch = notAtEnd(l.readChar())
+	for l.peekChar() != '`' {
+		var ch = l.readChar()
+		if ch == 0 {
+			panic(fmt.Sprintf("Unterminated raw string (%d:%d)", line, column))
+		}
 		if ch == '\n' {
-			l.line++
+			l.newline()
+		}
+	}
+	l.readChar()
+	return Token{Type: String, Literal: l.input[position : l.position-1], Line: line, Column: column}
+}
+
+// readHeredoc reads a <<EOF, <<"EOF", <<'EOF' or <<~EOF here-document. The
+// body starts on the line after the operator, or after the body of a
+// previous heredoc on the same line, and runs up to a line holding only the
+// terminator. With ~ the terminator may be indented, and its indentation is
+// stripped from every line of the body. Single quotes disable escapes and
+// interpolation.
+func (l *Lexer) readHeredoc(line int, column int) Token {
+	var strip = l.matchChar('~')
+	var quote rune
+	if l.peekChar() == '"' || l.peekChar() == '\'' {
+		quote = l.readChar()
+	}
+	var start = l.position
+	for isIdentifierPart(l.peekChar()) {
+		l.readChar()
+	}
+	var terminator = l.input[start:l.position]
+	if terminator == "" || quote != 0 && !l.matchChar(quote) {
+		panic(fmt.Sprintf("Invalid heredoc terminator (%d:%d)", line, column))
+	}
+	var bodyStart = l.skipTo
+	if !l.heredoc {
+		var lineEnd = strings.IndexByte(l.input[l.position:], '\n')
+		if lineEnd < 0 {
+			panic(fmt.Sprintf("Unterminated heredoc %s (%d:%d)", terminator, line, column))
+		}
+		l.skipFrom = l.position + lineEnd
+		bodyStart = l.skipFrom + 1
+	}
+	var bodyLine = l.line + 1 + l.skipLines
+	var lines []string
+	var indent string
+	var found = false
+	var position = bodyStart
+	for position < len(l.input) && !found {
+		var next = len(l.input)
+		if end := strings.IndexByte(l.input[position:], '\n'); end >= 0 {
+			next = position + end + 1
+		}
+		var text = strings.TrimSuffix(strings.TrimSuffix(l.input[position:next], "\n"), "\r")
+		if text == terminator || strip && strings.TrimLeft(text, " \t") == terminator {
+			indent = text[:len(text)-len(terminator)]
+			found = true
+		} else {
+			lines = append(lines, text)
+		}
+		position = next
+	}
+	if !found {
+		panic(fmt.Sprintf("Unterminated heredoc %s (%d:%d)", terminator, line, column))
+	}
+	l.heredoc = true
+	l.skipTo = position
+	l.skipLines += len(lines) + 1
+
+	var margin = 0
+	if strip {
+		margin = len(indent)
+		for j, text := range lines {
+			if strings.HasPrefix(text, indent) {
+				lines[j] = text[len(indent):]
+			} else {
+				lines[j] = strings.TrimLeft(text, " \t")
+			}
+		}
+	}
+	var body = ""
+	if len(lines) > 0 {
+		body = strings.Join(lines, "\n") + "\n"
+	}
+	if quote == '\'' {
+		return Token{Type: String, Literal: body, Line: line, Column: column}
+	}
+	var lexer = newLexerAt(body, bodyLine, margin)
+	lexer.margin = margin
+	return stringToken(lexer.scanString(0, true), line, column)
+}
+
+// scanString decodes string contents up to end, which is left unread. An end
+// of 0 reads to the end of the input. Embedded ${...} expressions split the
+// result into several parts.
+func (l *Lexer) scanString(end rune, interpolate bool) []StringPart {
+	var line, column = l.line, l.column - 1
+	var parts []StringPart
+	var text strings.Builder
+	var part = StringPart{Line: l.line, Column: l.column}
+	for l.peekChar() != end {
+		var ch = l.readChar()
+		switch {
+		case ch == 0:
+			panic(fmt.Sprintf("Unterminated string (%d:%d)", line, column))
+		case ch == '\n':
+			l.newline()
+			text.WriteRune(ch)
+		case ch == '\\':
+			l.readEscape(&text)
+		case ch == '$' && interpolate && l.peekChar() == '{':
+			part.Text = text.String()
+			parts = append(parts, part)
+			text.Reset()
+			l.readChar()
+			var expr = StringPart{Expr: true, Line: l.line, Column: l.column}
+			var start = l.position
+			l.skipInterpolation()
+			expr.Source = l.input[start : l.position-1]
+			parts = append(parts, expr)
+			part = StringPart{Line: l.line, Column: l.column}
+		default:
+			text.WriteRune(ch)
 		}
-		if ch == '\\' {
-			if l.peekChar() == ch {
+	}
+	part.Text = text.String()
+	return append(parts, part)
+}
+
+func stringToken(parts []StringPart, line int, column int) Token {
+	if len(parts) == 1 {
+		return Token{Type: String, Literal: parts[0].Text, Line: line, Column: column}
+	}
+	return Token{Type: String, Parts: parts, Line: line, Column: column}
+}
+
+var simpleEscapes = map[rune]string{
+	'n':  "\n",
+	'r':  "\r",
+	't':  "\t",
+	'0':  "\x00",
+	'a':  "\a",
+	'b':  "\b",
+	'f':  "\f",
+	'v':  "\v",
+	'e':  "\x1b",
+	'\\': "\\",
+	'"':  "\"",
+	'\'': "'",
+	'`':  "`",
+	'$':  "$",
+}
+
+// readEscape decodes the escape sequence following a backslash. Besides the
+// single-character escapes it accepts \xHH for a byte, and \uHHHH, \u{H...}
+// and \UHHHHHHHH for a unicode code point. A backslash at the end of a line
+// joins it with the next one.
+func (l *Lexer) readEscape(text *strings.Builder) {
+	var line, column = l.line, l.column - 1
+	var ch = l.readChar()
+	if escape, ok := simpleEscapes[ch]; ok {
+		text.WriteString(escape)
+		return
+	}
+	switch ch {
+	case '\n':
+		l.newline()
+	case 'x':
+		text.WriteByte(byte(l.readHex(2, line, column)))
+	case 'u':
+		var code rune
+		if l.matchChar('{') {
+			var start = l.position
+			for isHexDigit(l.peekChar()) {
 				l.readChar()
 			}
+			if l.position == start || l.position-start > 6 || !l.matchChar('}') {
+				panic(fmt.Sprintf("Invalid unicode escape (%d:%d)", line, column))
+			}
+			var value, _ = strconv.ParseUint(l.input[start:l.position-1], 16, 32)
+			code = rune(value)
+		} else {
+			code = l.readHex(4, line, column)
+		}
+		l.writeRune(text, code, line, column)
+	case 'U':
+		l.writeRune(text, l.readHex(8, line, column), line, column)
+	default:
+		if ch == 0 {
+			panic(fmt.Sprintf("Unterminated string (%d:%d)", line, column))
 		}
+		panic(fmt.Sprintf("Invalid escape sequence \\%c (%d:%d)", ch, line, column))
 	}
-	notAtEnd(l.readChar())
-	var literal = l.input[position : l.position-1]
-	literal = strings.ReplaceAll(literal, "\\"+string(ch), string(ch))
-	literal = strings.ReplaceAll(literal, "\\n", "\n")
-	literal = strings.ReplaceAll(literal, "\\r", "\r")
-	literal = strings.ReplaceAll(literal, "\\t", "\t")
-	return literal
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || ch == '$'
+func (l *Lexer) readHex(digits int, line int, column int) rune {
+	var value rune
+	for j := 0; j < digits; j++ {
+		var ch = l.peekChar()
+		if !isHexDigit(ch) {
+			panic(fmt.Sprintf("Invalid hex escape (%d:%d)", line, column))
+		}
+		l.readChar()
+		var digit, _ = strconv.ParseUint(string(ch), 16, 8)
+		value = value*16 + rune(digit)
+	}
+	return value
 }
 
-func isDigit(ch byte) bool {
+func (l *Lexer) writeRune(text *strings.Builder, code rune, line int, column int) {
+	if !utf8.ValidRune(code) {
+		panic(fmt.Sprintf("Invalid code point U+%X (%d:%d)", code, line, column))
+	}
+	text.WriteRune(code)
+}
+
+// skipInterpolation reads the source of an embedded expression up to and
+// including the closing brace, stepping over nested braces and strings.
+func (l *Lexer) skipInterpolation() {
+	var depth = 1
+	for depth > 0 {
+		var ch = notAtEnd(l.readChar())
+		switch ch {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '"', '\'':
+			l.readString(ch, l.line, l.column-1)
+		case '`':
+			l.readRaw(l.line, l.column-1)
+		case '\n':
+			l.newline()
+		}
+	}
+}
+
+func isLetter(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_' || ch == '$'
+}
+
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
+
+// isIdentifierPart reports whether ch may continue an identifier, which
+// includes digits and combining marks of any script.
+func isIdentifierPart(ch rune) bool {
+	return isLetter(ch) || unicode.IsDigit(ch) || unicode.IsMark(ch)
+}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,8 +12,10 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 var library = map[string]interface{}{}
@@ -27,8 +31,7 @@ func load(a string) interface{} {
 			panic(err)
 		}
 	}(file)
-	var b interface{}
-	err = json.Unmarshal([]byte(a), &b)
+	b, err := parseJSON([]byte(a))
 	if err != nil {
 		return nil
 	}
@@ -58,12 +61,13 @@ func save(a string, b interface{}) {
 	_, err = file.Write(data)
 }
 
-func buildFile(file *os.File) map[interface{}]interface{} {
-	return map[interface{}]interface{}{
+func buildFile(i *Interpreter, file *os.File) *Hash {
+	var r = i.reader(file)
+	return hashFrom(map[interface{}]interface{}{
 		"_file": file,
 		"read": func() interface{} {
 			var b []byte
-			_, err := file.Read(b)
+			_, err := r.Read(b)
 			if err != nil {
 				return err
 			}
@@ -71,7 +75,7 @@ func buildFile(file *os.File) map[interface{}]interface{} {
 		},
 		"read_b": func(a int) interface{} {
 			var b = make([]byte, a)
-			_, err := file.Read(b)
+			_, err := r.Read(b)
 			if err != nil {
 				return err
 			}
@@ -100,12 +104,15 @@ func buildFile(file *os.File) map[interface{}]interface{} {
 		},
 		"read_str": func() interface{} {
 			var b []byte
-			_, err := file.Read(b)
+			_, err := r.Read(b)
 			if err != nil {
 				return err
 			}
 			return string(b)
 		},
+		"lines": func() Iterator {
+			return linesIterator(r)
+		},
 		"close": func() interface{} {
 			err := file.Close()
 			if err != nil {
@@ -113,11 +120,11 @@ func buildFile(file *os.File) map[interface{}]interface{} {
 			}
 			return nil
 		},
-	}
+	})
 }
 
-func timeToMap(a time.Time) map[interface{}]interface{} {
-	return map[interface{}]interface{}{
+func timeToMap(a time.Time) *Hash {
+	return hashFrom(map[interface{}]interface{}{
 		"year":     a.Year(),
 		"month":    a.Month(),
 		"day":      a.Day(),
@@ -126,7 +133,7 @@ func timeToMap(a time.Time) map[interface{}]interface{} {
 		"second":   a.Second(),
 		"nsec":     a.Nanosecond(),
 		"location": a.Location().String(),
-	}
+	})
 }
 
 func convInt(a interface{}) int {
@@ -159,12 +166,13 @@ func convFloat(a interface{}) float64 {
 	}
 }
 
-func connToMap(a net.Conn) map[interface{}]interface{} {
-	return map[interface{}]interface{}{
+func connToMap(i *Interpreter, a net.Conn) *Hash {
+	var r = i.reader(a)
+	return hashFrom(map[interface{}]interface{}{
 		"_conn": a,
 		"read": func(n int) interface{} {
 			var b = make([]byte, n)
-			_, err := a.Read(b)
+			_, err := r.Read(b)
 			if err != nil {
 				return err
 			}
@@ -172,7 +180,7 @@ func connToMap(a net.Conn) map[interface{}]interface{} {
 		},
 		"read_all": func() interface{} {
 			var b []byte
-			_, err := io.ReadAll(a)
+			_, err := io.ReadAll(r)
 			if err != nil {
 				return err
 			}
@@ -180,26 +188,38 @@ func connToMap(a net.Conn) map[interface{}]interface{} {
 		},
 		"read_str": func() interface{} {
 			var b []byte
-			_, err := a.Read(b)
+			_, err := r.Read(b)
 			if err != nil {
 				return err
 			}
 			return string(b)
 		},
 		"write": func(b []byte) interface{} {
-			_, err := a.Write(b)
+			var err error
+			i.block(func() {
+				_, err = a.Write(b)
+			})
 			if err != nil {
 				return err
 			}
 			return nil
 		},
 		"write_str": func(b string) interface{} {
-			_, err := a.Write([]byte(b))
+			var err error
+			i.block(func() {
+				_, err = a.Write([]byte(b))
+			})
 			if err != nil {
 				return err
 			}
 			return nil
 		},
+		"lines": func() Iterator {
+			return linesIterator(r)
+		},
+		"chunks": func(n int) Iterator {
+			return chunksIterator(r, n)
+		},
 		"close": func() interface{} {
 			err := a.Close()
 			if err != nil {
@@ -209,20 +229,38 @@ func connToMap(a net.Conn) map[interface{}]interface{} {
 		},
 		"local":  a.LocalAddr().String(),
 		"remote": a.RemoteAddr().String(),
+	})
+}
+
+// valuesToHash converts the values of a header or form to a hash, with the
+// names in order.
+func valuesToHash(values map[string][]string) *Hash {
+	var names = make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var m = NewHash()
+	for _, name := range names {
+		m.Set(name, values[name])
 	}
+	return m
 }
 
-func respToMap(a *http.Response) map[interface{}]interface{} {
-	return map[interface{}]interface{}{
+// httpResponse converts the result of an HTTP request to a hash, reading
+// the whole body.
+func httpResponse(a *http.Response, err error) (*Hash, error) {
+	if err != nil {
+		return nil, err
+	}
+	return respToMap(a), nil
+}
+
+func respToMap(a *http.Response) *Hash {
+	return hashFrom(map[interface{}]interface{}{
 		"_response": a,
 		"status":    a.Status,
-		"header": func() map[interface{}]interface{} {
-			var m = map[interface{}]interface{}{}
-			for k, v := range a.Header {
-				m[k] = v
-			}
-			return m
-		}(),
+		"header":    valuesToHash(a.Header),
 		"body": func() interface{} {
 			b, err := io.ReadAll(a.Body)
 			if err != nil {
@@ -237,11 +275,11 @@ func respToMap(a *http.Response) map[interface{}]interface{} {
 			}
 			return nil
 		},
-	}
+	})
 }
 
-func requestToMap(a *http.Request) map[interface{}]interface{} {
-	return map[interface{}]interface{}{
+func requestToMap(a *http.Request) *Hash {
+	return hashFrom(map[interface{}]interface{}{
 		"_request": a,
 		"method":   a.Method,
 		"url":      a.URL.String(),
@@ -250,24 +288,14 @@ func requestToMap(a *http.Request) map[interface{}]interface{} {
 		"fragment": a.URL.Fragment,
 		"proto":    a.Proto,
 		"host":     a.RemoteAddr,
-		"form": func() map[interface{}]interface{} {
+		"form": func() *Hash {
 			err := a.ParseForm()
 			if err != nil {
 				return nil
 			}
-			var m = map[interface{}]interface{}{}
-			for k, v := range a.Form {
-				m[k] = v
-			}
-			return m
+			return valuesToHash(a.Form)
 		},
-		"header": func() map[interface{}]interface{} {
-			var m = map[interface{}]interface{}{}
-			for k, v := range a.Header {
-				m[k] = v
-			}
-			return m
-		}(),
+		"header": valuesToHash(a.Header),
 		"body": func() interface{} {
 			var b []byte
 			_, err := a.Body.Read(b)
@@ -283,13 +311,17 @@ func requestToMap(a *http.Request) map[interface{}]interface{} {
 			}
 			return nil
 		},
-	}
+	})
 }
 
 func init() {
-	library["print"] = fmt.Print
+	library["print"] = func(a ...interface{}) {
+		fmt.Print(printable(a)...)
+	}
 	doc_fn("print", ManyArgs("args"), "Prints the arguments to the standard output.", "nil")
-	library["println"] = fmt.Println
+	library["println"] = func(a ...interface{}) {
+		fmt.Println(printable(a)...)
+	}
 	doc_fn("println", ManyArgs("args"), "Prints the arguments to the standard output, followed by a newline.", "nil")
 	library["printf"] = fmt.Printf
 	doc_fn("printf", ManyArgs("format", "args"), "Prints the formatted arguments to the standard output.", "nil")
@@ -298,16 +330,22 @@ func init() {
 	library["len"] = func(a interface{}) int {
 		switch a.(type) {
 		case string:
-			return len(a.(string))
+			return utf8.RuneCountInString(a.(string))
 		case []interface{}:
 			return len(a.([]interface{}))
-		case map[interface{}]interface{}:
-			return len(a.(map[interface{}]interface{}))
+		case []byte:
+			return len(a.([]byte))
+		case *Set:
+			return a.(*Set).Len()
+		case Range:
+			return a.(Range).Len()
+		case *Hash:
+			return a.(*Hash).Len()
 		default:
 			return 0
 		}
 	}
-	doc_fn("len", ArgsOf("a"), "Returns the length of a string, array, or map.", "int")
+	doc_fn("len", ArgsOf("a"), "Returns the length of a string, bytes, array, range, set, or map. Strings are measured in code points.", "int")
 	library["push"] = func(a []interface{}, b interface{}) []interface{} {
 		return append(a, b)
 	}
@@ -341,17 +379,62 @@ func init() {
 	doc_fn("split", ArgsOf("a", "b"), "Splits a into substrings separated by b.", "array")
 	library["index"] = func(a []interface{}, b interface{}) int {
 		for i, v := range a {
-			if v == b {
+			if equal(v, b) {
 				return i
 			}
 		}
 		return -1
 	}
 	doc_fn("index", ArgsOf("a", "b"), "Returns the index of b in a, or -1 if b is not present.", "int")
-	library["slice"] = func(a []interface{}, b interface{}, c interface{}) []interface{} {
-		return a[convInt(b):convInt(c)]
+	library["slice"] = func(a interface{}, b interface{}, c interface{}) interface{} {
+		if str, ok := a.(string); ok {
+			return string([]rune(str)[convInt(b):convInt(c)])
+		}
+		if r, ok := a.(Range); ok {
+			return r.Slice(convInt(b), convInt(c))
+		}
+		if data, ok := a.([]byte); ok {
+			return append([]byte{}, data[convInt(b):convInt(c)]...)
+		}
+		return a.([]interface{})[convInt(b):convInt(c)]
+	}
+	doc_fn("slice", ArgsOf("a", "b", "c"), "Returns a slice of the array, string, bytes or range a from b to c. Strings are sliced by code points, and a slice of bytes is a copy.", "array, string, bytes or range")
+	library["bytes"] = func(a interface{}) []byte {
+		var data, ok = toBytes(a)
+		if !ok {
+			panic("Cannot convert " + fmt.Sprintf("%T", a) + " to bytes")
+		}
+		return append([]byte{}, data...)
+	}
+	doc_fn("bytes", ArgsOf("a"), "Returns the UTF-8 encoding of the string a, or the bytes in the array a, or a copy of the bytes a.", "bytes")
+	library["has"] = func(a interface{}, b interface{}) bool {
+		return hasKey(a, b)
+	}
+	doc_fn("has", ArgsOf("a", "b"), "Reports whether the hash a has the key b, or the array, string, bytes or range a has the index b.", "bool")
+	library["set"] = func(a interface{}) *Set {
+		switch a := a.(type) {
+		case *Set:
+			return NewSet(a.Elements()...)
+		case Range:
+			return NewSet(a.Array()...)
+		case []interface{}:
+			return NewSet(a...)
+		}
+		panic("Cannot make a set from " + fmt.Sprintf("%T", a))
+	}
+	doc_fn("set", ArgsOf("a"), "Returns a set of the elements of the array, range or set a.", "set")
+	library["union"] = func(a *Set, b *Set) *Set {
+		return a.Union(b)
 	}
-	doc_fn("slice", ArgsOf("a", "b", "c"), "Returns a slice of a from b to c.", "array")
+	doc_fn("union", ArgsOf("a", "b"), "Returns the elements that are in a or b.", "set")
+	library["intersection"] = func(a *Set, b *Set) *Set {
+		return a.Intersection(b)
+	}
+	doc_fn("intersection", ArgsOf("a", "b"), "Returns the elements that are in both a and b.", "set")
+	library["difference"] = func(a *Set, b *Set) *Set {
+		return a.Difference(b)
+	}
+	doc_fn("difference", ArgsOf("a", "b"), "Returns the elements of a that are not in b.", "set")
 	library["map"] = func(a []interface{}, b func(interface{}) interface{}) []interface{} {
 		var s []interface{}
 		for _, v := range a {
@@ -386,136 +469,177 @@ func init() {
 		return s
 	}
 	doc_fn("reverse", ArgsOf("a"), "Returns a new array containing the elements of a in reverse order.", "array")
-
-	library["file"] = map[interface{}]interface{}{
-		"persist": func(a string, b interface{}) interface{} {
-			// if a exists, load it and deserialize it, then return it
-			// if a does not exist, save b to a and return b
-			if _, err := os.Stat(a); err == nil {
-				// exists
-				return load(a)
-			}
-			// does not exist
-			save(a, b)
-			return b
-		},
-		"open": func(a string) interface{} {
-			var file, err = os.Open(a)
-			if err != nil {
-				return err
-			}
-			return buildFile(file)
-		},
-		"create": func(a string) interface{} {
-			var file, err = os.Create(a)
-			if err != nil {
-				return err
-			}
-			return buildFile(file)
-		},
-		"remove": func(a string) interface{} {
-			err := os.Remove(a)
-			if err != nil {
-				return err
+	library["assert"] = func(a interface{}, b ...interface{}) {
+		if !truthy(a) {
+			if len(b) > 0 {
+				panic(AssertionError{Message: "assert: " + fmt.Sprint(b...)})
 			}
-			return nil
-		},
-		"rename": func(a string, b string) interface{} {
-			err := os.Rename(a, b)
-			if err != nil {
-				return err
+			panic(AssertionError{Message: "assert: condition is " + repr(a)})
+		}
+	}
+	doc_fn("assert", ManyArgs("a", "message"), "Fails the current test unless a is truthy.", "nil")
+	library["assert_eq"] = func(a interface{}, b interface{}, c ...interface{}) {
+		if !equal(a, b) {
+			var message = assertEqualMessage(a, b)
+			if len(c) > 0 {
+				message = fmt.Sprint(c...) + "\n" + message
 			}
-			return nil
-		},
-		"stat": func(a string) interface{} {
-			var info, err = os.Stat(a)
-			if err != nil {
-				return err
+			panic(AssertionError{Message: message})
+		}
+	}
+	doc_fn("assert_eq", ManyArgs("got", "want", "message"), "Fails the current test unless got and want are deeply equal, showing a diff of the two.", "nil")
+	library["assert_raises"] = func(a interface{}, b ...string) (message string) {
+		defer func() {
+			var r = recover()
+			if r == nil {
+				panic(AssertionError{Message: "assert_raises: no error was raised"})
 			}
-			return map[interface{}]interface{}{
-				"name": info.Name(),
-				"size": info.Size(),
-				"mode": map[interface{}]interface{}{
-					"isdir":     info.Mode().IsDir(),
-					"isregular": info.Mode().IsRegular(),
-					"perm":      info.Mode().Perm(),
-				},
-				"modtime": timeToMap(info.ModTime()),
-				"isdir":   info.IsDir(),
+			message = panicMessage(r)
+			if len(b) > 0 && !strings.Contains(message, b[0]) {
+				panic(AssertionError{Message: "assert_raises: error " + repr(message) + " does not contain " + repr(b[0])})
 			}
-		},
+		}()
+		if block, ok := a.(func(interface{}) interface{}); ok {
+			block(nil)
+		} else {
+			callFunction(a, nil)
+		}
+		return
+	}
+	doc_fn("assert_raises", ManyArgs("f", "contains"), "Calls f and fails the current test unless it raises an error, optionally containing the given text. Returns the error message.", "string")
+
+	taskLibrary["file"] = func(i *Interpreter) interface{} {
+		return hashFrom(map[interface{}]interface{}{
+			"persist": func(a string, b interface{}) interface{} {
+				// if a exists, load it and deserialize it, then return it
+				// if a does not exist, save b to a and return b
+				if _, err := os.Stat(a); err == nil {
+					// exists
+					return load(a)
+				}
+				// does not exist
+				save(a, b)
+				return b
+			},
+			"open": func(a string) interface{} {
+				var file, err = os.Open(a)
+				if err != nil {
+					return err
+				}
+				return buildFile(i, file)
+			},
+			"create": func(a string) interface{} {
+				var file, err = os.Create(a)
+				if err != nil {
+					return err
+				}
+				return buildFile(i, file)
+			},
+			"remove": func(a string) interface{} {
+				err := os.Remove(a)
+				if err != nil {
+					return err
+				}
+				return nil
+			},
+			"rename": func(a string, b string) interface{} {
+				err := os.Rename(a, b)
+				if err != nil {
+					return err
+				}
+				return nil
+			},
+			"stat": func(a string) interface{} {
+				var info, err = os.Stat(a)
+				if err != nil {
+					return err
+				}
+				return hashFrom(map[interface{}]interface{}{
+					"name": info.Name(),
+					"size": info.Size(),
+					"mode": hashFrom(map[interface{}]interface{}{
+						"isdir":     info.Mode().IsDir(),
+						"isregular": info.Mode().IsRegular(),
+						"perm":      info.Mode().Perm(),
+					}),
+					"modtime": timeToMap(info.ModTime()),
+					"isdir":   info.IsDir(),
+				})
+			},
+		})
 	}
 	doc_obj("file_info",
 		"File info and operations.",
-		doc_fn_str("read", ArgsOf(), "Reads the file as an array of bytes.", "value"),
-		doc_fn_str("read_b", ArgsOf("n"), "Reads n bytes from the file.", "value"),
-		doc_fn_str("seek", ArgsOf("offset", "whence"), "Sets the position for the next read or write.", "nil"),
-		doc_fn_str("write", ArgsOf("data"), "Writes data to the file.", "nil"),
-		doc_fn_str("write_str", ArgsOf("data"), "Writes data to the file.", "nil"),
-		doc_fn_str("read_str", ArgsOf(), "Reads the file as a string.", "value"),
-		doc_fn_str("close", ArgsOf(), "Closes the file.", "nil"),
+		doc_fn_field("read", ArgsOf(), "Reads the file as an array of bytes.", "value"),
+		doc_fn_field("read_b", ArgsOf("n"), "Reads n bytes from the file.", "value"),
+		doc_fn_field("seek", ArgsOf("offset", "whence"), "Sets the position for the next read or write.", "nil"),
+		doc_fn_field("write", ArgsOf("data"), "Writes data to the file.", "nil"),
+		doc_fn_field("write_str", ArgsOf("data"), "Writes data to the file.", "nil"),
+		doc_fn_field("read_str", ArgsOf(), "Reads the file as a string.", "value"),
+		doc_fn_field("lines", ArgsOf(), "Returns an iterator over the lines of the file, for use in a for loop.", "iterator"),
+		doc_fn_field("close", ArgsOf(), "Closes the file.", "nil"),
 	)
 	doc_obj("stat_info",
 		"File info.",
-		doc_fn_str("name", ArgsOf(), "The name of the file.", "string"),
-		doc_fn_str("size", ArgsOf(), "The size of the file.", "int"),
-		doc_obj_str("mode",
+		doc_fn_field("name", ArgsOf(), "The name of the file.", "string"),
+		doc_fn_field("size", ArgsOf(), "The size of the file.", "int"),
+		doc_obj_field("mode",
 			"The mode of the file.",
-			doc_str("isdir", "True if the file is a directory."),
-			doc_str("isregular", "True if the file is a regular file."),
-			doc_str("perm", "The permission bits of the file."),
+			doc_field("isdir", "True if the file is a directory."),
+			doc_field("isregular", "True if the file is a regular file."),
+			doc_field("perm", "The permission bits of the file."),
 		),
-		doc_str("modtime", "The modification time of the file."),
-		doc_str("isdir", "True if the file is a directory."),
+		doc_field("modtime", "The modification time of the file."),
+		doc_field("isdir", "True if the file is a directory."),
 	)
 	doc_obj("file",
 		"File I/O operations.",
-		doc_fn_str("persist", ArgsOf("path", "data"), "Persists data to path if it does not exist, or loads and returns the data at path if it does.", "value"),
-		doc_fn_str("open", ArgsOf("path"), "Opens the file at path for reading.", "file_info"),
-		doc_fn_str("create", ArgsOf("path"), "Creates the file at path for writing.", "file_info"),
-		doc_fn_str("remove", ArgsOf("path"), "Removes the file at path.", "nil"),
-		doc_fn_str("rename", ArgsOf("old", "new"), "Renames the file at old to new.", "nil"),
-		doc_fn_str("stat", ArgsOf("path"), "Returns information about the file at path.", "stat_info"),
+		doc_fn_field("persist", ArgsOf("path", "data"), "Persists data to path if it does not exist, or loads and returns the data at path if it does.", "value"),
+		doc_fn_field("open", ArgsOf("path"), "Opens the file at path for reading.", "file_info"),
+		doc_fn_field("create", ArgsOf("path"), "Creates the file at path for writing.", "file_info"),
+		doc_fn_field("remove", ArgsOf("path"), "Removes the file at path.", "nil"),
+		doc_fn_field("rename", ArgsOf("old", "new"), "Renames the file at old to new.", "nil"),
+		doc_fn_field("stat", ArgsOf("path"), "Returns information about the file at path.", "stat_info"),
 	)
 
-	library["time"] = map[interface{}]interface{}{
-		"now": func() map[interface{}]interface{} {
+	library["time"] = hashFrom(map[interface{}]interface{}{
+		"now": func() *Hash {
 			return timeToMap(time.Now())
 		},
-		"parse": func(a string, b string) map[interface{}]interface{} {
+		"parse": func(a string, b string) *Hash {
 			var t, err = time.Parse(a, b)
 			if err != nil {
 				return nil
 			}
 			return timeToMap(t)
 		},
-		"format": func(a string, b map[interface{}]interface{}) string {
+		"format": func(a string, b *Hash) string {
 			var t = time.Date(
-				convInt(b["year"]),
-				time.Month(convInt(b["month"])),
-				convInt(b["day"]),
-				convInt(b["hour"]),
-				convInt(b["minute"]),
-				convInt(b["second"]),
-				convInt(b["nsec"]),
+				convInt(b.Value("year")),
+				time.Month(convInt(b.Value("month"))),
+				convInt(b.Value("day")),
+				convInt(b.Value("hour")),
+				convInt(b.Value("minute")),
+				convInt(b.Value("second")),
+				convInt(b.Value("nsec")),
 				time.FixedZone("", 0),
 			)
 			return t.Format(a)
 		},
-		"str": func(a map[interface{}]interface{}) string {
+		"str": func(a *Hash) string {
 			var t = time.Date(
-				convInt(a["year"]),
-				time.Month(convInt(a["month"])),
-				convInt(a["day"]),
-				convInt(a["hour"]),
-				convInt(a["minute"]),
-				convInt(a["second"]),
-				convInt(a["nsec"]),
+				convInt(a.Value("year")),
+				time.Month(convInt(a.Value("month"))),
+				convInt(a.Value("day")),
+				convInt(a.Value("hour")),
+				convInt(a.Value("minute")),
+				convInt(a.Value("second")),
+				convInt(a.Value("nsec")),
 				time.FixedZone("", 0),
 			)
 			return t.String()
 		},
-		"from_unix": func(a interface{}) map[interface{}]interface{} {
+		"from_unix": func(a interface{}) *Hash {
 			return timeToMap(time.Unix(int64(convInt(a)), 0))
 		},
 		"from": func(args ...interface{}) interface{} {
@@ -579,35 +703,35 @@ func init() {
 				return fmt.Errorf("time.from(): invalid arguments")
 			}
 		},
-		"diff": func(a map[interface{}]interface{}, b map[interface{}]interface{}) map[interface{}]interface{} {
+		"diff": func(a *Hash, b *Hash) *Hash {
 			var t1 = time.Date(
-				convInt(a["year"]),
-				time.Month(convInt(a["month"])),
-				convInt(a["day"]),
-				convInt(a["hour"]),
-				convInt(a["minute"]),
-				convInt(a["second"]),
-				convInt(a["nsec"]),
+				convInt(a.Value("year")),
+				time.Month(convInt(a.Value("month"))),
+				convInt(a.Value("day")),
+				convInt(a.Value("hour")),
+				convInt(a.Value("minute")),
+				convInt(a.Value("second")),
+				convInt(a.Value("nsec")),
 				time.FixedZone("", 0),
 			)
 			var t2 = time.Date(
-				convInt(b["year"]),
-				time.Month(convInt(b["month"])),
-				convInt(b["day"]),
-				convInt(b["hour"]),
-				convInt(b["minute"]),
-				convInt(b["second"]),
-				convInt(b["nsec"]),
+				convInt(b.Value("year")),
+				time.Month(convInt(b.Value("month"))),
+				convInt(b.Value("day")),
+				convInt(b.Value("hour")),
+				convInt(b.Value("minute")),
+				convInt(b.Value("second")),
+				convInt(b.Value("nsec")),
 				time.FixedZone("", 0),
 			)
 			var d = t2.Sub(t1)
-			return map[interface{}]interface{}{
+			return hashFrom(map[interface{}]interface{}{
 				"hours":   int(d.Hours()),
 				"minutes": int(d.Minutes()),
 				"seconds": int(d.Seconds()),
 				"mills":   int(d.Milliseconds()),
 				"nsec":    int(d.Nanoseconds()),
-			}
+			})
 		},
 		"January":   time.January,
 		"February":  time.February,
@@ -621,50 +745,50 @@ func init() {
 		"October":   time.October,
 		"November":  time.November,
 		"December":  time.December,
-	}
+	})
 	doc_obj("time_info",
 		"Time info.",
-		doc_str("year", "The year."),
-		doc_str("month", "The month."),
-		doc_str("day", "The day."),
-		doc_str("hour", "The hour."),
-		doc_str("minute", "The minute."),
-		doc_str("second", "The second."),
-		doc_str("nsec", "The nanosecond."),
-		doc_str("location", "The location."),
+		doc_field("year", "The year."),
+		doc_field("month", "The month."),
+		doc_field("day", "The day."),
+		doc_field("hour", "The hour."),
+		doc_field("minute", "The minute."),
+		doc_field("second", "The second."),
+		doc_field("nsec", "The nanosecond."),
+		doc_field("location", "The location."),
 	)
 	doc_obj("time_diff",
 		"Time difference.",
-		doc_str("hours", "The difference in hours."),
-		doc_str("minutes", "The difference in minutes."),
-		doc_str("seconds", "The difference in seconds."),
-		doc_str("mills", "The difference in milliseconds."),
-		doc_str("nsec", "The difference in nanoseconds."),
+		doc_field("hours", "The difference in hours."),
+		doc_field("minutes", "The difference in minutes."),
+		doc_field("seconds", "The difference in seconds."),
+		doc_field("mills", "The difference in milliseconds."),
+		doc_field("nsec", "The difference in nanoseconds."),
 	)
 	doc_obj("time",
 		"Time info and operations.",
-		doc_fn_str("now", ArgsOf(), "Returns the current time.", "time_info"),
-		doc_fn_str("parse", ArgsOf("layout", "value"), "Parses value using layout and returns the time.", "time_info"),
-		doc_fn_str("format", ArgsOf("layout", "time"), "Formats time using layout.", "string"),
-		doc_fn_str("str", ArgsOf("time"), "Returns a string representation of time.", "string"),
-		doc_fn_str("from_unix", ArgsOf("unix"), "Returns the time from a Unix timestamp.", "time_info"),
-		doc_fn_str("from", ManyArgs("year", "month", "day", "hour", "minute", "second", "nsec"), "Returns the time from the given year, month, day, hour, minute, second, and nanosecond. Granularity can be selected by omitting args.", "time_info"),
-		doc_fn_str("diff", ArgsOf("a", "b"), "Returns the difference between a and b.", "time_diff"),
-		doc_str("January", "The month of January."),
-		doc_str("February", "The month of February."),
-		doc_str("March", "The month of March."),
-		doc_str("April", "The month of April."),
-		doc_str("May", "The month of May."),
-		doc_str("June", "The month of June."),
-		doc_str("July", "The month of July."),
-		doc_str("August", "The month of August."),
-		doc_str("September", "The month of September."),
-		doc_str("October", "The month of October."),
-		doc_str("November", "The month of November."),
-		doc_str("December", "The month of December."),
+		doc_fn_field("now", ArgsOf(), "Returns the current time.", "time_info"),
+		doc_fn_field("parse", ArgsOf("layout", "value"), "Parses value using layout and returns the time.", "time_info"),
+		doc_fn_field("format", ArgsOf("layout", "time"), "Formats time using layout.", "string"),
+		doc_fn_field("str", ArgsOf("time"), "Returns a string representation of time.", "string"),
+		doc_fn_field("from_unix", ArgsOf("unix"), "Returns the time from a Unix timestamp.", "time_info"),
+		doc_fn_field("from", ManyArgs("year", "month", "day", "hour", "minute", "second", "nsec"), "Returns the time from the given year, month, day, hour, minute, second, and nanosecond. Granularity can be selected by omitting args.", "time_info"),
+		doc_fn_field("diff", ArgsOf("a", "b"), "Returns the difference between a and b.", "time_diff"),
+		doc_field("January", "The month of January."),
+		doc_field("February", "The month of February."),
+		doc_field("March", "The month of March."),
+		doc_field("April", "The month of April."),
+		doc_field("May", "The month of May."),
+		doc_field("June", "The month of June."),
+		doc_field("July", "The month of July."),
+		doc_field("August", "The month of August."),
+		doc_field("September", "The month of September."),
+		doc_field("October", "The month of October."),
+		doc_field("November", "The month of November."),
+		doc_field("December", "The month of December."),
 	)
 
-	library["math"] = map[interface{}]interface{}{
+	library["math"] = hashFrom(map[interface{}]interface{}{
 		"abs":       math.Abs,
 		"acos":      math.Acos,
 		"acosh":     math.Acosh,
@@ -705,289 +829,339 @@ func init() {
 		"epsilon":   math.SmallestNonzeroFloat64,
 		"pi":        math.Pi,
 		"e":         math.E,
-	}
+	})
 	doc_obj("math",
 		"Mathematical functions and constants.",
-		doc_fn_str("abs", ArgsOf("x"), "Returns the absolute value of x.", "float"),
-		doc_fn_str("acos", ArgsOf("x"), "Returns the arccosine of x.", "float"),
-		doc_fn_str("acosh", ArgsOf("x"), "Returns the hyperbolic arccosine of x.", "float"),
-		doc_fn_str("asin", ArgsOf("x"), "Returns the arcsine of x.", "float"),
-		doc_fn_str("asinh", ArgsOf("x"), "Returns the hyperbolic arcsine of x.", "float"),
-		doc_fn_str("atan", ArgsOf("x"), "Returns the arctangent of x.", "float"),
-		doc_fn_str("atan2", ArgsOf("y", "x"), "Returns the arctangent of y/x.", "float"),
-		doc_fn_str("atanh", ArgsOf("x"), "Returns the hyperbolic arctangent of x.", "float"),
-		doc_fn_str("cbrt", ArgsOf("x"), "Returns the cube root of x.", "float"),
-		doc_fn_str("ceil", ArgsOf("x"), "Returns the smallest integer value greater than or equal to x.", "float"),
-		doc_fn_str("copysign", ArgsOf("x", "y"), "Returns x with the sign of y.", "float"),
-		doc_fn_str("cos", ArgsOf("x"), "Returns the cosine of x.", "float"),
-		doc_fn_str("cosh", ArgsOf("x"), "Returns the hyperbolic cosine of x.", "float"),
-		doc_fn_str("exp", ArgsOf("x"), "Returns e**x.", "float"),
-		doc_fn_str("exp2", ArgsOf("x"), "Returns 2**x.", "float"),
-		doc_fn_str("floor", ArgsOf("x"), "Returns the largest integer value less than or equal to x.", "float"),
-		doc_fn_str("gamma", ArgsOf("x"), "Returns the gamma function of x.", "float"),
-		doc_fn_str("hypot", ArgsOf("x", "y"), "Returns the square root of x**2 + y**2.", "float"),
-		doc_fn_str("inf", ArgsOf("sign"), "Returns positive or negative infinity.", "float"),
-		doc_fn_str("log", ArgsOf("x"), "Returns the natural logarithm of x.", "float"),
-		doc_fn_str("log10", ArgsOf("x"), "Returns the base 10 logarithm of x.", "float"),
-		doc_fn_str("log2", ArgsOf("x"), "Returns the base 2 logarithm of x.", "float"),
-		doc_fn_str("max", ArgsOf("x", "y"), "Returns the larger of x or y.", "float"),
-		doc_fn_str("min", ArgsOf("x", "y"), "Returns the smaller of x or y.", "float"),
-		doc_fn_str("mod", ArgsOf("x", "y"), "Returns the floating-point remainder of x/y.", "float"),
-		doc_fn_str("nan", ArgsOf("sign"), "Returns a quiet NaN.", "float"),
-		doc_fn_str("pow", ArgsOf("x", "y"), "Returns x**y.", "float"),
-		doc_fn_str("pow10", ArgsOf("n"), "Returns 10**n.", "float"),
-		doc_fn_str("remainder", ArgsOf("x", "y"), "Returns the IEEE 754 floating-point remainder of x/y.", "float"),
-		doc_fn_str("round", ArgsOf("x"), "Returns the nearest integer, rounding half away from zero.", "float"),
-		doc_fn_str("signbit", ArgsOf("x"), "Reports whether x is negative.", "bool"),
-		doc_fn_str("sin", ArgsOf("x"), "Returns the sine of x.", "float"),
-		doc_fn_str("sinh", ArgsOf("x"), "Returns the hyperbolic sine of x.", "float"),
-		doc_fn_str("sqrt", ArgsOf("x"), "Returns the square root of x.", "float"),
-		doc_fn_str("tan", ArgsOf("x"), "Returns the tangent of x.", "float"),
-		doc_fn_str("tanh", ArgsOf("x"), "Returns the hyperbolic tangent of x.", "float"),
-		doc_fn_str("trunc", ArgsOf("x"), "Returns the integer value of x.", "float"),
-		doc_str("epsilon", "The smallest positive number that can be represented as a float64."),
-		doc_str("pi", "The ratio of the circumference of a circle to its diameter."),
-		doc_str("e", "The base of the natural logarithm."),
+		doc_fn_field("abs", ArgsOf("x"), "Returns the absolute value of x.", "float"),
+		doc_fn_field("acos", ArgsOf("x"), "Returns the arccosine of x.", "float"),
+		doc_fn_field("acosh", ArgsOf("x"), "Returns the hyperbolic arccosine of x.", "float"),
+		doc_fn_field("asin", ArgsOf("x"), "Returns the arcsine of x.", "float"),
+		doc_fn_field("asinh", ArgsOf("x"), "Returns the hyperbolic arcsine of x.", "float"),
+		doc_fn_field("atan", ArgsOf("x"), "Returns the arctangent of x.", "float"),
+		doc_fn_field("atan2", ArgsOf("y", "x"), "Returns the arctangent of y/x.", "float"),
+		doc_fn_field("atanh", ArgsOf("x"), "Returns the hyperbolic arctangent of x.", "float"),
+		doc_fn_field("cbrt", ArgsOf("x"), "Returns the cube root of x.", "float"),
+		doc_fn_field("ceil", ArgsOf("x"), "Returns the smallest integer value greater than or equal to x.", "float"),
+		doc_fn_field("copysign", ArgsOf("x", "y"), "Returns x with the sign of y.", "float"),
+		doc_fn_field("cos", ArgsOf("x"), "Returns the cosine of x.", "float"),
+		doc_fn_field("cosh", ArgsOf("x"), "Returns the hyperbolic cosine of x.", "float"),
+		doc_fn_field("exp", ArgsOf("x"), "Returns e**x.", "float"),
+		doc_fn_field("exp2", ArgsOf("x"), "Returns 2**x.", "float"),
+		doc_fn_field("floor", ArgsOf("x"), "Returns the largest integer value less than or equal to x.", "float"),
+		doc_fn_field("gamma", ArgsOf("x"), "Returns the gamma function of x.", "float"),
+		doc_fn_field("hypot", ArgsOf("x", "y"), "Returns the square root of x**2 + y**2.", "float"),
+		doc_fn_field("inf", ArgsOf("sign"), "Returns positive or negative infinity.", "float"),
+		doc_fn_field("log", ArgsOf("x"), "Returns the natural logarithm of x.", "float"),
+		doc_fn_field("log10", ArgsOf("x"), "Returns the base 10 logarithm of x.", "float"),
+		doc_fn_field("log2", ArgsOf("x"), "Returns the base 2 logarithm of x.", "float"),
+		doc_fn_field("max", ArgsOf("x", "y"), "Returns the larger of x or y.", "float"),
+		doc_fn_field("min", ArgsOf("x", "y"), "Returns the smaller of x or y.", "float"),
+		doc_fn_field("mod", ArgsOf("x", "y"), "Returns the floating-point remainder of x/y.", "float"),
+		doc_fn_field("nan", ArgsOf("sign"), "Returns a quiet NaN.", "float"),
+		doc_fn_field("pow", ArgsOf("x", "y"), "Returns x**y.", "float"),
+		doc_fn_field("pow10", ArgsOf("n"), "Returns 10**n.", "float"),
+		doc_fn_field("remainder", ArgsOf("x", "y"), "Returns the IEEE 754 floating-point remainder of x/y.", "float"),
+		doc_fn_field("round", ArgsOf("x"), "Returns the nearest integer, rounding half away from zero.", "float"),
+		doc_fn_field("signbit", ArgsOf("x"), "Reports whether x is negative.", "bool"),
+		doc_fn_field("sin", ArgsOf("x"), "Returns the sine of x.", "float"),
+		doc_fn_field("sinh", ArgsOf("x"), "Returns the hyperbolic sine of x.", "float"),
+		doc_fn_field("sqrt", ArgsOf("x"), "Returns the square root of x.", "float"),
+		doc_fn_field("tan", ArgsOf("x"), "Returns the tangent of x.", "float"),
+		doc_fn_field("tanh", ArgsOf("x"), "Returns the hyperbolic tangent of x.", "float"),
+		doc_fn_field("trunc", ArgsOf("x"), "Returns the integer value of x.", "float"),
+		doc_field("epsilon", "The smallest positive number that can be represented as a float64."),
+		doc_field("pi", "The ratio of the circumference of a circle to its diameter."),
+		doc_field("e", "The base of the natural logarithm."),
 	)
-	library["net"] = map[interface{}]interface{}{
-		"resolve": func(a string) interface{} {
-			var ips, err = net.LookupIP(a)
-			if err != nil {
-				return err
-			}
-			var s []string
-			for _, ip := range ips {
-				s = append([]string{ip.String()}, s...)
-			}
-			return s
-		},
-		"lookup": func(a string) interface{} {
-			var ips, err = net.LookupAddr(a)
-			if err != nil {
-				return err
-			}
-			return ips
-		},
-		"dial_tcp": func(a string, b string) interface{} {
-			var conn, err = net.Dial("tcp", a+":"+b)
-			if err != nil {
-				return err
-			}
-			return connToMap(conn)
-		},
-		"dial_udp": func(a string, b string) interface{} {
-			var conn, err = net.Dial("udp", a+":"+b)
-			if err != nil {
-				return err
-			}
-			return connToMap(conn)
-		},
-		"listen_tcp": func(a string) interface{} {
-			var listener, err = net.Listen("tcp", a)
-			if err != nil {
-				return err
-			}
-			return map[interface{}]interface{}{
-				"_listener": listener,
-				"accept": func() interface{} {
-					var conn, err = listener.Accept()
-					if err != nil {
-						return err
-					}
-					return connToMap(conn)
-				},
-				"close": func() interface{} {
-					err := listener.Close()
-					if err != nil {
-						return err
-					}
-					return nil
-				},
-			}
-		},
-		"listen_udp": func(a string) interface{} {
-			var listener, err = net.ListenPacket("udp", a)
-			if err != nil {
-				return err
-			}
-			return map[interface{}]interface{}{
-				"_listener": listener,
-				"read": func(b []byte) interface{} {
-					n, addr, err := listener.ReadFrom(b)
-					if err != nil {
-						return err
-					}
-					return map[interface{}]interface{}{
-						"n":    n,
-						"addr": addr.String(),
-					}
-				},
-				"write": func(b []byte) interface{} {
-					_, err := listener.WriteTo(b, listener.LocalAddr())
-					if err != nil {
-						return err
-					}
-					return nil
-				},
-				"write_str": func(b string) interface{} {
-					_, err := listener.WriteTo([]byte(b), listener.LocalAddr())
-					if err != nil {
-						return err
-					}
-					return nil
-				},
-				"close": func() interface{} {
-					err := listener.Close()
-					if err != nil {
-						return err
-					}
-					return nil
-				},
-			}
-		},
+	taskLibrary["net"] = func(i *Interpreter) interface{} {
+		return hashFrom(map[interface{}]interface{}{
+			"resolve": func(a string) interface{} {
+				var ips []net.IP
+				var err error
+				i.block(func() {
+					ips, err = net.LookupIP(a)
+				})
+				if err != nil {
+					return err
+				}
+				var s []string
+				for _, ip := range ips {
+					s = append([]string{ip.String()}, s...)
+				}
+				return s
+			},
+			"lookup": func(a string) interface{} {
+				var ips []string
+				var err error
+				i.block(func() {
+					ips, err = net.LookupAddr(a)
+				})
+				if err != nil {
+					return err
+				}
+				return ips
+			},
+			"dial_tcp": func(a string, b string) interface{} {
+				var conn net.Conn
+				var err error
+				i.block(func() {
+					conn, err = net.Dial("tcp", a+":"+b)
+				})
+				if err != nil {
+					return err
+				}
+				return connToMap(i, conn)
+			},
+			"dial_udp": func(a string, b string) interface{} {
+				var conn net.Conn
+				var err error
+				i.block(func() {
+					conn, err = net.Dial("udp", a+":"+b)
+				})
+				if err != nil {
+					return err
+				}
+				return connToMap(i, conn)
+			},
+			"listen_tcp": func(a string) interface{} {
+				var listener, err = net.Listen("tcp", a)
+				if err != nil {
+					return err
+				}
+				return hashFrom(map[interface{}]interface{}{
+					"_listener": listener,
+					"addr":      listener.Addr().String(),
+					"accept": func() interface{} {
+						var conn net.Conn
+						var err error
+						i.block(func() {
+							conn, err = listener.Accept()
+						})
+						if err != nil {
+							return err
+						}
+						return connToMap(i, conn)
+					},
+					"close": func() interface{} {
+						err := listener.Close()
+						if err != nil {
+							return err
+						}
+						return nil
+					},
+				})
+			},
+			"listen_udp": func(a string) interface{} {
+				var listener, err = net.ListenPacket("udp", a)
+				if err != nil {
+					return err
+				}
+				return hashFrom(map[interface{}]interface{}{
+					"_listener": listener,
+					"read": func(b []byte) interface{} {
+						var n int
+						var addr net.Addr
+						var err error
+						i.block(func() {
+							n, addr, err = listener.ReadFrom(b)
+						})
+						if err != nil {
+							return err
+						}
+						return hashFrom(map[interface{}]interface{}{
+							"n":    n,
+							"addr": addr.String(),
+						})
+					},
+					"write": func(b []byte) interface{} {
+						_, err := listener.WriteTo(b, listener.LocalAddr())
+						if err != nil {
+							return err
+						}
+						return nil
+					},
+					"write_str": func(b string) interface{} {
+						_, err := listener.WriteTo([]byte(b), listener.LocalAddr())
+						if err != nil {
+							return err
+						}
+						return nil
+					},
+					"close": func() interface{} {
+						err := listener.Close()
+						if err != nil {
+							return err
+						}
+						return nil
+					},
+				})
+			},
+		})
 	}
 	doc_obj("conn",
 		"Network connection.",
-		doc_fn_str("read", ArgsOf("n"), "Reads n bytes from the connection.", "nil"),
-		doc_fn_str("read_all", ArgsOf(), "Reads all available bytes from the connection.", "byte array"),
-		doc_fn_str("read_str", ArgsOf(), "Reads all available bytes from the connection as a string.", "string"),
-		doc_fn_str("write", ArgsOf("data"), "Writes the byte array data to the connection.", "nil"),
-		doc_fn_str("write_str", ArgsOf("data"), "Writes the string data to the connection.", "nil"),
-		doc_fn_str("close", ArgsOf(), "Closes the connection.", "nil"),
-		doc_str("local", "The local address of the connection."),
-		doc_str("remote", "The remote address of the connection."),
+		doc_fn_field("read", ArgsOf("n"), "Reads n bytes from the connection.", "nil"),
+		doc_fn_field("read_all", ArgsOf(), "Reads all available bytes from the connection.", "byte array"),
+		doc_fn_field("read_str", ArgsOf(), "Reads all available bytes from the connection as a string.", "string"),
+		doc_fn_field("write", ArgsOf("data"), "Writes the byte array data to the connection.", "nil"),
+		doc_fn_field("write_str", ArgsOf("data"), "Writes the string data to the connection.", "nil"),
+		doc_fn_field("lines", ArgsOf(), "Returns an iterator over the lines read from the connection until it is closed.", "iterator"),
+		doc_fn_field("chunks", ArgsOf("n"), "Returns an iterator over byte arrays of at most n bytes read from the connection until it is closed.", "iterator"),
+		doc_fn_field("close", ArgsOf(), "Closes the connection.", "nil"),
+		doc_field("local", "The local address of the connection."),
+		doc_field("remote", "The remote address of the connection."),
 	)
 	doc_obj("tcp_listener",
 		"TCP network listener.",
-		doc_fn_str("accept", ArgsOf(), "Accepts a connection.", "conn"),
-		doc_fn_str("close", ArgsOf(), "Closes the listener.", "nil"),
+		doc_fn_field("accept", ArgsOf(), "Accepts a connection.", "conn"),
+		doc_field("addr", "The address the listener is listening on."),
+		doc_fn_field("close", ArgsOf(), "Closes the listener.", "nil"),
 	)
 	doc_obj("udp_listener",
 		"UDP network listener.",
-		doc_fn_str("read", ArgsOf("n"), "Reads n bytes from the port.", "value"),
-		doc_fn_str("write", ArgsOf("data"), "Writes data to the port.", "nil"),
-		doc_fn_str("write_str", ArgsOf("data"), "Writes data to the port.", "nil"),
-		doc_fn_str("close", ArgsOf(), "Closes the listener.", "nil"),
+		doc_fn_field("read", ArgsOf("n"), "Reads n bytes from the port.", "value"),
+		doc_fn_field("write", ArgsOf("data"), "Writes data to the port.", "nil"),
+		doc_fn_field("write_str", ArgsOf("data"), "Writes data to the port.", "nil"),
+		doc_fn_field("close", ArgsOf(), "Closes the listener.", "nil"),
 	)
 	doc_obj("net",
 		"Network operations.",
-		doc_fn_str("resolve", ArgsOf("host"), "Resolves the IP addresses of a host.", "array"),
-		doc_fn_str("lookup", ArgsOf("ip"), "Looks up the hostnames of an IP address.", "array"),
-		doc_fn_str("dial_tcp", ArgsOf("host", "port"), "Dials a TCP connection to a host and port.", "conn"),
-		doc_fn_str("dial_udp", ArgsOf("host", "port"), "Dials a UDP connection to a host and port.", "conn"),
-		doc_fn_str("listen_tcp", ArgsOf("port"), "Listens for TCP connections on a port.", "tcp_listener"),
-		doc_fn_str("listen_udp", ArgsOf("port"), "Listens for UDP connections on a port.", "udp_listener"),
+		doc_fn_field("resolve", ArgsOf("host"), "Resolves the IP addresses of a host.", "array"),
+		doc_fn_field("lookup", ArgsOf("ip"), "Looks up the hostnames of an IP address.", "array"),
+		doc_fn_field("dial_tcp", ArgsOf("host", "port"), "Dials a TCP connection to a host and port.", "conn"),
+		doc_fn_field("dial_udp", ArgsOf("host", "port"), "Dials a UDP connection to a host and port.", "conn"),
+		doc_fn_field("listen_tcp", ArgsOf("port"), "Listens for TCP connections on a port.", "tcp_listener"),
+		doc_fn_field("listen_udp", ArgsOf("port"), "Listens for UDP connections on a port.", "udp_listener"),
 	)
-	library["http"] = map[interface{}]interface{}{
-		"get": func(a string) interface{} {
-			var client = &http.Client{}
-			var resp, err = client.Get(a)
-			if err != nil {
-				return err
-			}
-			return respToMap(resp)
-		},
-		"post": func(a string, b string, c string) interface{} {
-			var client = &http.Client{}
-			var resp, err = client.Post(a, b, strings.NewReader(c))
-			if err != nil {
-				return err
-			}
-			return respToMap(resp)
-		},
-		"head": func(a string) interface{} {
-			var client = &http.Client{}
-			var resp, err = client.Head(a)
-			if err != nil {
-				return err
-			}
-			return respToMap(resp)
-		},
-		"new_request": func(a string, b string, c string) interface{} {
-			var req, err = http.NewRequest(a, b, strings.NewReader(c))
-			if err != nil {
-				return err
-			}
-			return map[interface{}]interface{}{
-				"_request": req,
-				"header": func() map[interface{}]interface{} {
-					var m = map[interface{}]interface{}{}
-					for k, v := range req.Header {
-						m[k] = v
-					}
-					return m
-				}(),
-				"body": func() interface{} {
-					var b []byte
-					_, err := req.Body.Read(b)
-					if err != nil {
-						return err
-					}
-					return string(b)
-				}(),
-				"close": func() interface{} {
-					err := req.Body.Close()
-					if err != nil {
-						return err
-					}
-					return nil
-				},
-			}
-		},
-		"do": func(a map[interface{}]interface{}) interface{} {
-			var client = &http.Client{}
-			var req = a["_request"].(*http.Request)
-			var resp, err = client.Do(req)
-			if err != nil {
-				return err
-			}
-			return respToMap(resp)
-		},
-		"server": func(a string, b func(...interface{}) interface{}) interface{} {
-			return http.ListenAndServe(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				var m = requestToMap(r)
-				var resp = b(m).(map[interface{}]interface{})
-				w.WriteHeader(convInt(resp["status"]))
-				for k, v := range resp["header"].(map[interface{}]interface{}) {
-					w.Header().Add(k.(string), v.(string))
+	taskLibrary["http"] = func(i *Interpreter) interface{} {
+		return hashFrom(map[interface{}]interface{}{
+			"get": func(a string) interface{} {
+				var client = &http.Client{}
+				var resp *Hash
+				var err error
+				i.block(func() {
+					resp, err = httpResponse(client.Get(a))
+				})
+				if err != nil {
+					return err
 				}
-				_, _ = w.Write([]byte(resp["body"].(string)))
-			}))
-		},
-		"response": func(a int, b map[interface{}]interface{}, c string) map[interface{}]interface{} {
-			return map[interface{}]interface{}{
-				"status": a,
-				"header": b,
-				"body":   c,
-			}
-		},
+				return resp
+			},
+			"post": func(a string, b string, c string) interface{} {
+				var client = &http.Client{}
+				var resp *Hash
+				var err error
+				i.block(func() {
+					resp, err = httpResponse(client.Post(a, b, strings.NewReader(c)))
+				})
+				if err != nil {
+					return err
+				}
+				return resp
+			},
+			"head": func(a string) interface{} {
+				var client = &http.Client{}
+				var resp *Hash
+				var err error
+				i.block(func() {
+					resp, err = httpResponse(client.Head(a))
+				})
+				if err != nil {
+					return err
+				}
+				return resp
+			},
+			"new_request": func(a string, b string, c string) interface{} {
+				var req, err = http.NewRequest(a, b, strings.NewReader(c))
+				if err != nil {
+					return err
+				}
+				return hashFrom(map[interface{}]interface{}{
+					"_request": req,
+					"header":   valuesToHash(req.Header),
+					"body": func() interface{} {
+						var b []byte
+						_, err := req.Body.Read(b)
+						if err != nil {
+							return err
+						}
+						return string(b)
+					}(),
+					"close": func() interface{} {
+						err := req.Body.Close()
+						if err != nil {
+							return err
+						}
+						return nil
+					},
+				})
+			},
+			"do": func(a *Hash) interface{} {
+				var client = &http.Client{}
+				var req = a.Value("_request").(*http.Request)
+				var resp *Hash
+				var err error
+				i.block(func() {
+					resp, err = httpResponse(client.Do(req))
+				})
+				if err != nil {
+					return err
+				}
+				return resp
+			},
+			"server": func(a string, b func(...interface{}) interface{}) interface{} {
+				var err error
+				i.block(func() {
+					err = http.ListenAndServe(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						var m = requestToMap(r)
+						i.callback(func() {
+							var resp = b(m).(*Hash)
+							w.WriteHeader(convInt(resp.Value("status")))
+							var header = resp.Value("header").(*Hash)
+							for n := 0; n < header.Len(); n++ {
+								var k, v = header.At(n)
+								w.Header().Add(k.(string), v.(string))
+							}
+							_, _ = w.Write([]byte(resp.Value("body").(string)))
+						})
+					}))
+				})
+				return err
+			},
+			"response": func(a int, b *Hash, c string) *Hash {
+				return hashFrom(map[interface{}]interface{}{
+					"status": a,
+					"header": b,
+					"body":   c,
+				})
+			},
+		})
 	}
 	doc_obj("http_request",
 		"HTTP request.",
-		doc_str("header", "The request header."),
-		doc_str("body", "The request body."),
-		doc_fn_str("close", ArgsOf(), "Closes the request body.", "nil"),
+		doc_field("header", "The request header."),
+		doc_field("body", "The request body."),
+		doc_fn_field("close", ArgsOf(), "Closes the request body.", "nil"),
 	)
 	doc_obj("http_response",
 		"HTTP response.",
-		doc_str("status", "The response status code."),
-		doc_str("header", "The response header."),
-		doc_str("body", "The response body."),
+		doc_field("status", "The response status code."),
+		doc_field("header", "The response header."),
+		doc_field("body", "The response body."),
 	)
 	doc_obj("http",
 		"HTTP operations.",
-		doc_fn_str("get", ArgsOf("url"), "Performs an HTTP GET request.", "http_response"),
-		doc_fn_str("post", ArgsOf("url", "type", "data"), "Performs an HTTP POST request.", "http_response"),
-		doc_fn_str("head", ArgsOf("url"), "Performs an HTTP HEAD request.", "http_response"),
-		doc_fn_str("new_request", ArgsOf("method", "url", "data"), "Creates a new HTTP request.", "http_request"),
-		doc_fn_str("do", ArgsOf("request"), "Performs an HTTP request.", "http_response"),
-		doc_fn_str("server", ArgsOf("addr", "handler"), "Starts an HTTP server.", "nil"),
-		doc_fn_str("response", ArgsOf("status", "header", "body"), "Creates an HTTP response.", "http_response"),
+		doc_fn_field("get", ArgsOf("url"), "Performs an HTTP GET request.", "http_response"),
+		doc_fn_field("post", ArgsOf("url", "type", "data"), "Performs an HTTP POST request.", "http_response"),
+		doc_fn_field("head", ArgsOf("url"), "Performs an HTTP HEAD request.", "http_response"),
+		doc_fn_field("new_request", ArgsOf("method", "url", "data"), "Creates a new HTTP request.", "http_request"),
+		doc_fn_field("do", ArgsOf("request"), "Performs an HTTP request.", "http_response"),
+		doc_fn_field("server", ArgsOf("addr", "handler"), "Starts an HTTP server.", "nil"),
+		doc_fn_field("response", ArgsOf("status", "header", "body"), "Creates an HTTP response.", "http_response"),
 	)
-	library["json"] = map[interface{}]interface{}{
+	library["json"] = hashFrom(map[interface{}]interface{}{
 		"from": func(a string) interface{} {
-			var b interface{}
-			err := json.Unmarshal([]byte(a), &b)
+			b, err := parseJSON([]byte(a))
 			if err != nil {
 				return err
 			}
@@ -1003,123 +1177,176 @@ func init() {
 		"valid": func(a string) bool {
 			return json.Valid([]byte(a))
 		},
-	}
+	})
 	doc_obj("json",
 		"JSON operations.",
-		doc_fn_str("from", ArgsOf("string"), "Parses a JSON string.", "value"),
-		doc_fn_str("to", ArgsOf("value"), "Serializes a value to a JSON string.", "string"),
-		doc_fn_str("valid", ArgsOf("string"), "Reports whether a string is a valid JSON.", "bool"),
+		doc_fn_field("from", ArgsOf("string"), "Parses a JSON string.", "value"),
+		doc_fn_field("to", ArgsOf("value"), "Serializes a value to a JSON string.", "string"),
+		doc_fn_field("valid", ArgsOf("string"), "Reports whether a string is a valid JSON.", "bool"),
 	)
-	library["os"] = map[interface{}]interface{}{
-		"args": func() []string {
-			return os.Args
-		},
-		"env": func(a string) string {
-			return os.Getenv(a)
+	library["hex"] = hashFrom(map[interface{}]interface{}{
+		"to": func(a []byte) string {
+			return hex.EncodeToString(a)
 		},
-		"setenv": func(a string, b string) {
-			err := os.Setenv(a, b)
-			if err != nil {
-				return
-			}
-		},
-		"unsetenv": func(a string) {
-			err := os.Unsetenv(a)
-			if err != nil {
-				return
-			}
-		},
-		"getwd": func() string {
-			s, err := os.Getwd()
-			if err != nil {
-				return ""
-			}
-			return s
-		},
-		"chdir": func(a string) {
-			err := os.Chdir(a)
+		"from": func(a string) interface{} {
+			b, err := hex.DecodeString(a)
 			if err != nil {
-				return
+				return err
 			}
+			return b
 		},
-		"mkdir": func(a string) {
-			err := os.Mkdir(a, 0755)
-			if err != nil {
-				return
-			}
+	})
+	doc_obj("hex",
+		"Hexadecimal encoding of bytes.",
+		doc_fn_field("to", ArgsOf("data"), "Encodes bytes or a string as hexadecimal.", "string"),
+		doc_fn_field("from", ArgsOf("string"), "Decodes a hexadecimal string.", "bytes"),
+	)
+	library["base64"] = hashFrom(map[interface{}]interface{}{
+		"to": func(a []byte) string {
+			return base64.StdEncoding.EncodeToString(a)
 		},
-		"mkdir_all": func(a string) {
-			err := os.MkdirAll(a, 0755)
+		"from": func(a string) interface{} {
+			b, err := base64.StdEncoding.DecodeString(a)
 			if err != nil {
-				return
+				return err
 			}
+			return b
 		},
-		"cp": func(a string, b string) {
-			src, err := os.Open(a)
-			if err != nil {
-				return
-			}
-			defer func(src *os.File) {
-				err := src.Close()
+	})
+	doc_obj("base64",
+		"Base64 encoding of bytes.",
+		doc_fn_field("to", ArgsOf("data"), "Encodes bytes or a string as standard base64.", "string"),
+		doc_fn_field("from", ArgsOf("string"), "Decodes a standard base64 string.", "bytes"),
+	)
+	taskLibrary["os"] = func(i *Interpreter) interface{} {
+		return hashFrom(map[interface{}]interface{}{
+			"args": func() []string {
+				return os.Args
+			},
+			"env": func(a string) string {
+				return os.Getenv(a)
+			},
+			"setenv": func(a string, b string) {
+				err := os.Setenv(a, b)
 				if err != nil {
 					return
 				}
-			}(src)
-			dst, err := os.Create(b)
-			if err != nil {
-				return
-			}
-			defer func(dst *os.File) {
-				err := dst.Close()
+			},
+			"unsetenv": func(a string) {
+				err := os.Unsetenv(a)
 				if err != nil {
 					return
 				}
-			}(dst)
-			_, err = io.Copy(dst, src)
-			if err != nil {
-				return
-			}
-		},
-		"mv": func(a string, b string) {
-			err := os.Rename(a, b)
-			if err != nil {
-				return
-			}
-		},
-		"system": func(a string) interface{} {
-			err := exec.Command(strings.Split(a, " ")[0], strings.Split(a, " ")[1:]...).Run()
-			if err != nil {
-				return err
-			}
-			return nil
-		},
-		"os": runtime.GOOS,
-		"exit": func(a int) {
-			os.Exit(a)
-		},
+			},
+			"getwd": func() string {
+				s, err := os.Getwd()
+				if err != nil {
+					return ""
+				}
+				return s
+			},
+			"chdir": func(a string) {
+				err := os.Chdir(a)
+				if err != nil {
+					return
+				}
+			},
+			"mkdir": func(a string, b ...interface{}) {
+				var mode os.FileMode = 0755
+				if len(b) > 0 {
+					mode = os.FileMode(convInt(b[0]))
+				}
+				err := os.Mkdir(a, mode)
+				if err != nil {
+					return
+				}
+			},
+			"mkdir_all": func(a string, b ...interface{}) {
+				var mode os.FileMode = 0755
+				if len(b) > 0 {
+					mode = os.FileMode(convInt(b[0]))
+				}
+				err := os.MkdirAll(a, mode)
+				if err != nil {
+					return
+				}
+			},
+			"cp": func(a string, b string) {
+				src, err := os.Open(a)
+				if err != nil {
+					return
+				}
+				defer func(src *os.File) {
+					err := src.Close()
+					if err != nil {
+						return
+					}
+				}(src)
+				dst, err := os.Create(b)
+				if err != nil {
+					return
+				}
+				defer func(dst *os.File) {
+					err := dst.Close()
+					if err != nil {
+						return
+					}
+				}(dst)
+				_, err = io.Copy(dst, src)
+				if err != nil {
+					return
+				}
+			},
+			"mv": func(a string, b string) {
+				err := os.Rename(a, b)
+				if err != nil {
+					return
+				}
+			},
+			"system": func(a string) interface{} {
+				var err error
+				i.block(func() {
+					err = exec.Command(strings.Split(a, " ")[0], strings.Split(a, " ")[1:]...).Run()
+				})
+				if err != nil {
+					return err
+				}
+				return nil
+			},
+			"os": runtime.GOOS,
+			"exit": func(a int) {
+				os.Exit(a)
+			},
+		})
 	}
 	doc_obj("os",
 		"Operating system operations.",
-		doc_fn_str("args", ArgsOf(), "Returns the command-line arguments.", "array"),
-		doc_fn_str("env", ArgsOf("key"), "Returns the value of an environment variable.", "string"),
-		doc_fn_str("setenv", ArgsOf("key", "value"), "Sets the value of an environment variable.", "nil"),
-		doc_fn_str("unsetenv", ArgsOf("key"), "Unsets an environment variable.", "nil"),
-		doc_fn_str("getwd", ArgsOf(), "Returns the current working directory.", "string"),
-		doc_fn_str("chdir", ArgsOf("dir"), "Changes the current working directory.", "nil"),
-		doc_fn_str("mkdir", ArgsOf("dir"), "Creates a directory.", "nil"),
-		doc_fn_str("mkdir_all", ArgsOf("dir"), "Creates a directory and any necessary parents.", "nil"),
-		doc_fn_str("cp", ArgsOf("src", "dst"), "Copies a file.", "nil"),
-		doc_fn_str("mv", ArgsOf("src", "dst"), "Moves a file.", "nil"),
-		doc_fn_str("system", ArgsOf("command"), "Executes a system command.", "nil"),
-		doc_str("os", "The operating system."),
-		doc_fn_str("exit", ArgsOf("code"), "Exits the program with a status code.", "nil"),
+		doc_fn_field("args", ArgsOf(), "Returns the command-line arguments.", "array"),
+		doc_fn_field("env", ArgsOf("key"), "Returns the value of an environment variable.", "string"),
+		doc_fn_field("setenv", ArgsOf("key", "value"), "Sets the value of an environment variable.", "nil"),
+		doc_fn_field("unsetenv", ArgsOf("key"), "Unsets an environment variable.", "nil"),
+		doc_fn_field("getwd", ArgsOf(), "Returns the current working directory.", "string"),
+		doc_fn_field("chdir", ArgsOf("dir"), "Changes the current working directory.", "nil"),
+		doc_fn_field("mkdir", ManyArgs("dir", "mode"), "Creates a directory with the permission bits mode, 0o755 by default.", "nil"),
+		doc_fn_field("mkdir_all", ManyArgs("dir", "mode"), "Creates a directory and any necessary parents with the permission bits mode, 0o755 by default.", "nil"),
+		doc_fn_field("cp", ArgsOf("src", "dst"), "Copies a file.", "nil"),
+		doc_fn_field("mv", ArgsOf("src", "dst"), "Moves a file.", "nil"),
+		doc_fn_field("system", ArgsOf("command"), "Executes a system command.", "nil"),
+		doc_field("os", "The operating system."),
+		doc_fn_field("exit", ArgsOf("code"), "Exits the program with a status code.", "nil"),
 	)
-	library["exec"] = func(a string, b ...string) interface{} {
-		out, err := exec.Command(a, b...).Output()
-		if err != nil {
-			return err
+	taskLibrary["exec"] = func(i *Interpreter) interface{} {
+		return func(a string, b ...string) interface{} {
+			var out []byte
+			var err error
+			i.block(func() {
+				out, err = exec.Command(a, b...).Output()
+			})
+			if err != nil {
+				return err
+			}
+			return string(out)
 		}
-		return string(out)
 	}
 	doc_fn("exec", ArgsOf("command", "args"), "Executes a system command.", "value")
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+)
+
+// namedArgs holds the named arguments of a call. It is passed to a sub
+// after the positional arguments, where bind takes it off again.
+type namedArgs struct {
+	hash *Hash
+}
+
+// evalArgs evaluates the arguments of a call. Spread arguments add their
+// elements, and named arguments and spread hashes are gathered into a
+// namedArgs at the end.
+func (i *Interpreter) evalArgs(exprs []Expression) []interface{} {
+	var args = make([]interface{}, 0, len(exprs))
+	var named *Hash
+	var name = func(key interface{}, value interface{}) {
+		if named == nil {
+			named = NewHash()
+		}
+		named.Set(key, value)
+	}
+	for _, expr := range exprs {
+		switch expr := expr.(type) {
+		case *Spread:
+			var value = i.eval(expr.Value)
+			if hash, ok := value.(*Hash); ok {
+				for n := 0; n < hash.Len(); n++ {
+					name(hash.At(n))
+				}
+			} else {
+				args = append(args, spread(value)...)
+			}
+		case *NamedArgument:
+			name(expr.Name, i.eval(expr.Value))
+		default:
+			args = append(args, i.eval(expr))
+		}
+	}
+	if named != nil {
+		args = append(args, namedArgs{named})
+	}
+	return args
+}
+
+// spread returns the elements of an array, set, range, bytes or iterator.
+func spread(value interface{}) []interface{} {
+	switch value := value.(type) {
+	case []interface{}:
+		return value
+	case *Set:
+		return value.Elements()
+	case Range:
+		return value.Array()
+	case []byte:
+		var result = make([]interface{}, len(value))
+		for j, b := range value {
+			result[j] = int(b)
+		}
+		return result
+	}
+	if it, ok := iterator(value); ok {
+		var result []interface{}
+		for element, ok := it.Next(); ok; element, ok = it.Next() {
+			result = append(result, element)
+		}
+		return result
+	}
+	panic("Cannot spread " + fmt.Sprintf("%T", value))
+}
+
+// bind sets the parameters of the sub called name in scope. Positional
+// arguments fill the parameters in order, with any left over going to the
+// rest parameter; named arguments fill the parameters they name; and the
+// parameters still missing get their defaults. Defaults are evaluated in
+// order in the sub's scope, so they can use the parameters before them.
+func (i *Interpreter) bind(name string, params []Param, args []interface{}, scope map[string]interface{}) {
+	var named *Hash
+	if len(args) > 0 {
+		if last, ok := args[len(args)-1].(namedArgs); ok {
+			named = last.hash
+			args = args[:len(args)-1]
+		}
+	}
+	var j, max = 0, 0
+	for _, param := range params {
+		if param.Rest {
+			scope[param.Name] = append([]interface{}{}, args[j:]...)
+			j = len(args)
+			continue
+		}
+		max++
+		if j < len(args) {
+			scope[param.Name] = args[j]
+			j++
+		}
+	}
+	if j < len(args) {
+		panic(fmt.Sprintf("Too many arguments to %s: expected at most %d, got %d", name, max, len(args)))
+	}
+	for n := 0; named != nil && n < named.Len(); n++ {
+		var key, value = named.At(n)
+		var param, ok = key.(string)
+		if !ok || !hasParam(params, param) {
+			panic("Unknown argument to " + name + ": " + stringify(key))
+		}
+		if _, ok := scope[param]; ok {
+			panic("Argument given twice to " + name + ": " + param)
+		}
+		scope[param] = value
+	}
+	for _, param := range params {
+		if _, ok := scope[param.Name]; ok {
+			continue
+		}
+		if param.Default == nil {
+			panic("Missing argument to " + name + ": " + param.Name)
+		}
+		scope[param.Name] = i.eval(param.Default)
+	}
+}
+
+// hasParam reports whether params has a parameter called name that can be
+// passed by name.
+func hasParam(params []Param, name string) bool {
+	for _, param := range params {
+		if param.Name == name && !param.Rest {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type Parser struct {
 	lexer *Lexer
 	token Token
+	prev  Token
+	// generator is set while parsing the body of a sub, and marks it as a
+	// generator when the body contains yield.
+	generator *bool
+	// noIn is set while parsing the variables of a for loop, whose in is
+	// not an operator.
+	noIn bool
+	// valueBranches is set while parsing an if, unless or when expression,
+	// whose branches give values.
+	valueBranches bool
 }
 
 func NewParser(lexer *Lexer) *Parser {
@@ -15,6 +28,15 @@ func NewParser(lexer *Lexer) *Parser {
 	return parser
 }
 
+func Parse(source string) []Statement {
+	var parser = NewParser(NewLexer(source))
+	var program []Statement
+	for !parser.peek(Eof) {
+		program = append(program, parser.stmt())
+	}
+	return program
+}
+
 func (p *Parser) peek(type_ TokenType) bool {
 	return p.token.Type == type_
 }
@@ -25,12 +47,19 @@ func (p *Parser) next() TokenType {
 
 func (p *Parser) match(t TokenType) bool {
 	if p.next() == t {
+		p.prev = p.token
 		p.token = p.lexer.NextToken()
 		return true
 	}
 	return false
 }
 
+// adjacent reports whether the current token directly follows the previous
+// one, with no whitespace in between.
+func (p *Parser) adjacent() bool {
+	return p.token.Line == p.prev.Line && p.token.Column == p.prev.Column+utf8.RuneCountInString(p.prev.Literal)
+}
+
 func (p *Parser) eat(t TokenType) Token {
 	var token = p.token
 	if !p.match(t) {
@@ -40,7 +69,54 @@ func (p *Parser) eat(t TokenType) Token {
 }
 
 func (p *Parser) expr() Expression {
-	return p.or()
+	return p.assignment()
+}
+
+// assignOperators are the tokens that assign to the expression on their
+// left.
+var assignOperators = map[TokenType]bool{
+	Assign:         true,
+	PlusAssign:     true,
+	MinusAssign:    true,
+	MultiplyAssign: true,
+	DivideAssign:   true,
+	ModuloAssign:   true,
+	AppendAssign:   true,
+}
+
+// assignment is right associative, so `a = b = 0` assigns 0 to both.
+func (p *Parser) assignment() Expression {
+	var expr = p.coalesce()
+	if op := p.next(); assignOperators[op] && isAssignable(expr) {
+		var pos = TokenPos(p.token)
+		p.eat(op)
+		return &Assignment{Left: expr, Operator: op, Value: p.assignment(), Pos: pos}
+	}
+	return expr
+}
+
+func isAssignable(expr Expression) bool {
+	switch expr := expr.(type) {
+	case *Variable:
+		return true
+	case *Index:
+		return !expr.Optional
+	case *Member:
+		return !expr.Optional
+	}
+	return false
+}
+
+// coalesce parses a ?? b, which is a unless a is nil, and then b. It binds
+// looser than or, so that `a | b ?? c` tests a | b for nil.
+func (p *Parser) coalesce() Expression {
+	var expr = p.or()
+	for p.peek(Coalesce) {
+		var pos = TokenPos(p.token)
+		p.eat(Coalesce)
+		expr = &Binary{Left: expr, Operator: Coalesce, Right: p.or(), Pos: pos}
+	}
+	return expr
 }
 
 func (p *Parser) or() Expression {
@@ -73,9 +149,72 @@ func (p *Parser) equality() Expression {
 	return expr
 }
 
+// comparison parses the ordering operators, and in and not in, which test
+// whether the left operand is an element of the right one. They all bind
+// looser than arithmetic and ranges and tighter than == and !=, so that
+// `x + 1 in 1..n == ok` groups as `((x + 1) in (1..n)) == ok`.
 func (p *Parser) comparison() Expression {
+	var expr = p.rangeExpr()
+	for op := p.next(); op == Less || op == LessEqual || op == Greater || op == GreaterEqual || op == In && !p.noIn || op == NotIn; op = p.next() {
+		var pos = TokenPos(p.token)
+		p.eat(op)
+		expr = &Binary{Left: expr, Operator: op, Right: p.rangeExpr(), Pos: pos}
+	}
+	return expr
+}
+
+// rangeExpr parses start..end, start..<end and an optional `by step`. The
+// bounds may be any arithmetic or bitwise expression, as in 0..<len(xs)-1.
+func (p *Parser) rangeExpr() Expression {
+	var expr = p.bitOr()
+	if p.peek(DotDot) {
+		var pos = TokenPos(p.token)
+		p.eat(DotDot)
+		var result = &RangeExpression{Start: expr, Exclusive: p.match(Less), Pos: pos}
+		result.End = p.bitOr()
+		if p.match(By) {
+			result.Step = p.bitOr()
+		}
+		return result
+	}
+	return expr
+}
+
+// The bitwise operators bind tighter than comparisons, so that
+// `mode band 0o111 != 0` tests the masked bits.
+func (p *Parser) bitOr() Expression {
+	var expr = p.bitXor()
+	for p.peek(BitOr) {
+		var pos = TokenPos(p.token)
+		p.eat(BitOr)
+		expr = &Binary{Left: expr, Operator: BitOr, Right: p.bitXor(), Pos: pos}
+	}
+	return expr
+}
+
+func (p *Parser) bitXor() Expression {
+	var expr = p.bitAnd()
+	for p.peek(BitXor) {
+		var pos = TokenPos(p.token)
+		p.eat(BitXor)
+		expr = &Binary{Left: expr, Operator: BitXor, Right: p.bitAnd(), Pos: pos}
+	}
+	return expr
+}
+
+func (p *Parser) bitAnd() Expression {
+	var expr = p.shift()
+	for p.peek(BitAnd) {
+		var pos = TokenPos(p.token)
+		p.eat(BitAnd)
+		expr = &Binary{Left: expr, Operator: BitAnd, Right: p.shift(), Pos: pos}
+	}
+	return expr
+}
+
+func (p *Parser) shift() Expression {
 	var expr = p.addition()
-	for op := p.next(); op == Less || op == LessEqual || op == Greater || op == GreaterEqual; op = p.next() {
+	for op := p.next(); op == ShiftLeft || op == ShiftRight; op = p.next() {
 		var pos = TokenPos(p.token)
 		p.eat(op)
 		expr = &Binary{Left: expr, Operator: op, Right: p.addition(), Pos: pos}
@@ -111,19 +250,46 @@ func (p *Parser) unary() Expression {
 	if p.match(Minus) {
 		return &Unary{Operator: Minus, Right: p.unary(), Pos: pos}
 	}
-	return p.call(false)
+	if p.match(BitNot) {
+		return &Unary{Operator: BitNot, Right: p.unary(), Pos: pos}
+	}
+	return p.power()
 }
 
+// power is right associative and binds tighter than a unary operator on its
+// left, so `-2 ** 2` is -4 and `2 ** 3 ** 2` is 512.
+func (p *Parser) power() Expression {
+	var expr = p.call(false)
+	if p.peek(Power) {
+		var pos = TokenPos(p.token)
+		p.eat(Power)
+		return &Binary{Left: expr, Operator: Power, Right: p.unary(), Pos: pos}
+	}
+	return expr
+}
+
+// call parses a primary expression followed by calls, member accesses and
+// indexes. At the start of a statement (dotOnly), calls and indexes must be
+// written without a space, so that `f(x)` and `a[0]` are told apart from
+// `print (x)` and `print [1, 2]`.
 func (p *Parser) call(dotOnly bool) Expression {
-	var expr = p.primary()
 	var pos = TokenPos(p.token)
+	var expr = p.primary()
 	for {
-		if p.match(LeftParen) && !dotOnly {
+		if (!dotOnly || p.adjacent()) && p.match(LeftParen) {
 			expr = p.finishCall(expr, pos)
 		} else if p.match(Dot) {
 			expr = p.finishMember(expr, pos)
-		} else if p.match(LeftBracket) && !dotOnly {
+		} else if (!dotOnly || p.adjacent()) && p.match(LeftBracket) {
 			expr = p.finishIndex(expr, pos)
+		} else if p.match(OptionalDot) {
+			var member = p.finishMember(expr, pos).(*Member)
+			member.Optional = true
+			expr = member
+		} else if p.match(OptionalBracket) {
+			var index = p.finishIndex(expr, pos).(*Index)
+			index.Optional = true
+			expr = index
 		} else {
 			break
 		}
@@ -134,7 +300,7 @@ func (p *Parser) call(dotOnly bool) Expression {
 func (p *Parser) finishCall(expr Expression, pos Pos) Expression {
 	var args []Expression
 	for !p.match(RightParen) {
-		args = append(args, p.expr())
+		args = append(args, p.argument())
 		if p.next() != RightParen {
 			p.eat(Comma)
 		}
@@ -142,6 +308,24 @@ func (p *Parser) finishCall(expr Expression, pos Pos) Expression {
 	return &Call{Function: expr, Args: args, Pos: pos}
 }
 
+// argument parses an argument of a call: an expression, a ...spread or a
+// name: value named argument.
+func (p *Parser) argument() Expression {
+	var pos = TokenPos(p.token)
+	if p.match(Ellipsis) {
+		return &Spread{Value: p.expr(), Pos: pos}
+	}
+	if p.peek(Id) {
+		var lexer, token, prev = *p.lexer, p.token, p.prev
+		var name = p.eat(Id).Literal
+		if p.match(Colon) {
+			return &NamedArgument{Name: name, Value: p.expr(), Pos: pos}
+		}
+		*p.lexer, p.token, p.prev = lexer, token, prev
+	}
+	return p.expr()
+}
+
 func (p *Parser) finishMember(expr Expression, pos Pos) Expression {
 	return &Member{Left: expr, Member: p.eat(Id).Literal, Pos: pos}
 }
@@ -158,14 +342,21 @@ func (p *Parser) primary() Expression {
 	case Id:
 		return &Variable{Name: p.eat(Id).Literal, Pos: pos}
 	case String:
-		return &StringLiteral{Value: p.eat(String).Literal, Pos: pos}
+		var token = p.eat(String)
+		if token.Parts != nil {
+			return p.interpolation(token)
+		}
+		return &StringLiteral{Value: token.Literal, Pos: pos}
+	case Bytes:
+		return &BytesLiteral{Value: []byte(p.eat(Bytes).Literal), Pos: pos}
 	case Number:
 		var number = p.eat(Number)
-		if i, err := strconv.Atoi(number.Literal); err == nil {
-			return &NumberLiteral[int]{Value: i, Pos: pos}
+		switch value := parseNumber(number).(type) {
+		case int:
+			return &NumberLiteral[int]{Value: value, Pos: pos}
+		default:
+			return &NumberLiteral[float64]{Value: value.(float64), Pos: pos}
 		}
-		var f, _ = strconv.ParseFloat(number.Literal, 64)
-		return &NumberLiteral[float64]{Value: f, Pos: pos}
 	case True:
 		p.eat(True)
 		return &BooleanLiteral{Value: true, Pos: pos}
@@ -182,8 +373,35 @@ func (p *Parser) primary() Expression {
 		return expr
 	case LeftBracket:
 		return p.array()
+	case SetBracket:
+		p.eat(SetBracket)
+		var values = []Expression{}
+		for !p.peek(RightBracket) {
+			values = append(values, p.expr())
+			if !p.match(Comma) {
+				break
+			}
+		}
+		p.eat(RightBracket)
+		return &SetLiteral{Values: values, Pos: pos}
 	case LeftBrace:
 		return p.exprBlock()
+	case Spawn:
+		return p.spawn()
+	case If, Unless, When:
+		var valueBranches = p.valueBranches
+		p.valueBranches = true
+		var stmt Statement
+		switch p.next() {
+		case If:
+			stmt = p.ifStmt()
+		case Unless:
+			stmt = p.unlessStmt()
+		default:
+			stmt = p.whenStmt()
+		}
+		p.valueBranches = valueBranches
+		return &BranchExpression{Statement: stmt, Pos: pos}
 	case Inc:
 		p.eat(Inc)
 		var value = p.expr()
@@ -203,26 +421,48 @@ func (p *Parser) primary() Expression {
 	}
 }
 
+// interpolation parses each embedded expression of a string with a parser
+// of its own, positioned where the expression starts in the source.
+func (p *Parser) interpolation(token Token) Expression {
+	var parts []Expression
+	var starts []Pos
+	for _, part := range token.Parts {
+		if !part.Expr {
+			if part.Text != "" {
+				parts = append(parts, &StringLiteral{Value: part.Text, Pos: NewPos(part.Line, part.Column)})
+				starts = append(starts, NewPos(part.Line, part.Column))
+			}
+			continue
+		}
+		var parser = NewParser(newLexerAt(part.Source, part.Line, part.Column))
+		starts = append(starts, TokenPos(parser.token))
+		parts = append(parts, parser.expr())
+		if !parser.peek(Eof) {
+			panic("unexpected token: " + parser.token.String())
+		}
+	}
+	return &Interpolation{Parts: parts, Starts: starts, Pos: TokenPos(token)}
+}
+
 func (p *Parser) array() Expression {
 	var pos = TokenPos(p.token)
 	p.eat(LeftBracket)
 	if p.match(Colon) {
 		p.eat(RightBracket)
-		return &HashLiteral{Pairs: map[Expression]Expression{}, Pos: pos}
+		return &HashLiteral{Pairs: []Pair{}, Pos: pos}
 	} else if p.match(RightBracket) {
 		return &ArrayLiteral{Values: []Expression{}, Pos: pos}
 	} else {
 		var first = p.expr()
 		if p.match(Colon) {
-			var values = map[Expression]Expression{}
-			values[first] = p.expr()
+			var pairs = []Pair{{first, p.expr()}}
 			for p.match(Comma) {
 				var key = p.expr()
 				p.eat(Colon)
-				values[key] = p.expr()
+				pairs = append(pairs, Pair{key, p.expr()})
 			}
 			p.eat(RightBracket)
-			return &HashLiteral{Pairs: values, Pos: pos}
+			return &HashLiteral{Pairs: pairs, Pos: pos}
 		} else {
 			var values = []Expression{first}
 			for p.match(Comma) {
@@ -267,7 +507,10 @@ func (p *Parser) stmt() Statement {
 	case Sub:
 		stmt = p.subStmt()
 		return stmt
-	case My:
+	case Class:
+		stmt = p.classStmt()
+		return stmt
+	case My, Const:
 		stmt = p.myStmt()
 		p.eat(Semicolon)
 		return stmt
@@ -276,6 +519,16 @@ func (p *Parser) stmt() Statement {
 		return stmt
 	case Return:
 		stmt = p.returnStmt()
+	case Yield:
+		var pos = TokenPos(p.token)
+		p.eat(Yield)
+		if p.generator == nil {
+			panic("yield outside of a sub: " + p.prev.String())
+		}
+		*p.generator = true
+		stmt = &YieldStatement{Value: p.expr(), Pos: pos}
+	case Spawn:
+		stmt = p.spawn()
 	case Inc:
 		var pos = TokenPos(p.token)
 		p.eat(Inc)
@@ -297,17 +550,16 @@ func (p *Parser) stmt() Statement {
 	default:
 		var pos = TokenPos(p.token)
 		var left = p.call(true)
-		if p.match(Assign) {
+		if op := p.next(); assignOperators[op] {
+			p.eat(op)
 			var right = p.expr()
-			stmt = &AssignmentStatement{Left: left, Value: right, Pos: pos}
+			stmt = &AssignmentStatement{Left: left, Operator: op, Value: right, Pos: pos}
+		} else if call, ok := left.(*Call); ok && p.atStatementEnd() {
+			stmt = &CallStatement{Function: call.Function, Args: call.Args, Pos: pos}
 		} else {
 			var args []Expression
-			for !p.peek(Semicolon) &&
-				!p.peek(If) &&
-				!p.peek(Unless) &&
-				!p.peek(While) &&
-				!p.peek(Until) {
-				args = append(args, p.expr())
+			for !p.atStatementEnd() {
+				args = append(args, p.argument())
 			}
 			stmt = &CallStatement{Function: left, Args: args, Pos: pos}
 		}
@@ -340,39 +592,44 @@ loop:
 	return stmt
 }
 
+// spawn parses spawn followed by a call.
+func (p *Parser) spawn() *SpawnExpression {
+	var pos = TokenPos(p.token)
+	p.eat(Spawn)
+	var call, ok = p.call(false).(*Call)
+	if !ok {
+		panic("spawn needs a call: " + p.prev.String())
+	}
+	return &SpawnExpression{Call: call, Pos: pos}
+}
+
+// atStatementEnd reports whether the current token ends a simple statement,
+// either with a semicolon or with a trailing modifier.
+func (p *Parser) atStatementEnd() bool {
+	return p.peek(Semicolon) || p.peek(If) || p.peek(Unless) || p.peek(While) || p.peek(Until)
+}
+
 func (p *Parser) ifStmt() Statement {
 	var pos = TokenPos(p.token)
 	p.eat(If)
 	var condition = p.expr()
-	var then []Statement
 	p.eat(LeftBrace)
-	for !p.match(RightBrace) {
-		then = append(then, p.stmt())
-	}
+	var then = p.branchBody()
 	var elseIfs []ElseIf
 	for p.match(Else) {
 		if p.match(If) {
 			var condition = p.expr()
-			var elseIfThen []Statement
 			p.eat(LeftBrace)
-			for !p.match(RightBrace) {
-				elseIfThen = append(elseIfThen, p.stmt())
-			}
+			var elseIfThen = p.branchBody()
 			elseIfs = append(elseIfs, ElseIf{Condition: condition, Then: elseIfThen})
 		} else if p.match(Unless) {
 			var condition = p.expr()
-			var unlessThen []Statement
 			p.eat(LeftBrace)
-			for !p.match(RightBrace) {
-				unlessThen = append(unlessThen, p.stmt())
-			}
+			var unlessThen = p.branchBody()
 			elseIfs = append(elseIfs, ElseIf{Condition: &Unary{Operator: Not, Right: condition, Pos: condition.PosFrom()}, Then: unlessThen})
 		} else {
-			var elseThen []Statement
 			p.eat(LeftBrace)
-			for !p.match(RightBrace) {
-				elseThen = append(elseThen, p.stmt())
-			}
+			var elseThen = p.branchBody()
 			return &IfStatement{Conditions: condition, Then: then, ElseIfs: elseIfs, Else_: elseThen, Pos: pos}
 		}
 	}
@@ -383,41 +640,74 @@ func (p *Parser) unlessStmt() Statement {
 	var pos = TokenPos(p.token)
 	p.eat(Unless)
 	var condition = p.expr()
-	var then []Statement
 	p.eat(LeftBrace)
-	for !p.match(RightBrace) {
-		then = append(then, p.stmt())
-	}
+	var then = p.branchBody()
 	var elseIfs []ElseIf
 	for p.match(Else) {
 		if p.match(If) {
 			var condition = p.expr()
-			var elseIfThen []Statement
 			p.eat(LeftBrace)
-			for !p.match(RightBrace) {
-				elseIfThen = append(elseIfThen, p.stmt())
-			}
+			var elseIfThen = p.branchBody()
 			elseIfs = append(elseIfs, ElseIf{Condition: condition, Then: elseIfThen})
 		} else if p.match(Unless) {
 			var condition = p.expr()
-			var unlessThen []Statement
 			p.eat(LeftBrace)
-			for !p.match(RightBrace) {
-				unlessThen = append(unlessThen, p.stmt())
-			}
+			var unlessThen = p.branchBody()
 			elseIfs = append(elseIfs, ElseIf{Condition: &Unary{Operator: Not, Right: condition, Pos: condition.PosFrom()}, Then: unlessThen})
 		} else {
-			var elseThen []Statement
 			p.eat(LeftBrace)
-			for !p.match(RightBrace) {
-				elseThen = append(elseThen, p.stmt())
-			}
+			var elseThen = p.branchBody()
 			return &UnlessStatement{Condition: condition, Then: then, ElseIfs: elseIfs, Else_: elseThen, Pos: pos}
 		}
 	}
 	return &UnlessStatement{Condition: condition, Then: then, ElseIfs: nil, Else_: nil, Pos: pos}
 }
 
+// statementKeywords start statements that cannot be expressions.
+var statementKeywords = map[TokenType]bool{
+	While:  true,
+	Until:  true,
+	For:    true,
+	Do:     true,
+	Sub:    true,
+	Class:  true,
+	My:     true,
+	Const:  true,
+	Return: true,
+	Yield:  true,
+	Inc:    true,
+	Dec:    true,
+}
+
+// branchBody parses the statements of a branch of an if, unless or when up
+// to the closing brace. In an if, unless or when expression, an expression
+// that ends the branch without a semicolon becomes a ValueStatement, which
+// gives the value of the whole expression.
+func (p *Parser) branchBody() []Statement {
+	var valueBranches = p.valueBranches
+	p.valueBranches = false
+	defer func() {
+		p.valueBranches = valueBranches
+	}()
+	var body []Statement
+	for !p.match(RightBrace) {
+		if valueBranches && !statementKeywords[p.next()] {
+			// parse an expression, and go back to parse a statement if
+			// the branch doesn't end after it
+			var lexer, token, prev = *p.lexer, p.token, p.prev
+			var pos = TokenPos(p.token)
+			var value = p.expr()
+			if p.match(RightBrace) {
+				body = append(body, &ValueStatement{Value: value, Pos: pos})
+				break
+			}
+			*p.lexer, p.token, p.prev = lexer, token, prev
+		}
+		body = append(body, p.stmt())
+	}
+	return body
+}
+
 func (p *Parser) whileStmt() Statement {
 	var pos = TokenPos(p.token)
 	p.eat(While)
@@ -445,23 +735,49 @@ func (p *Parser) untilStmt() Statement {
 func (p *Parser) forStmt() Statement {
 	var pos = TokenPos(p.token)
 	p.eat(For)
+	if p.peek(LeftBracket) {
+		// parse a destructuring pattern as my does, and go back to parse
+		// an array to loop over if no in follows it
+		var lexer, token, prev = *p.lexer, p.token, p.prev
+		if pattern := p.forPattern(); pattern != nil && p.match(In) {
+			var right = p.expr()
+			return &ForStatement{Pattern: pattern, Expression: right, Body: p.forBody(), Pos: pos}
+		}
+		*p.lexer, p.token, p.prev = lexer, token, prev
+	}
+	var noIn = p.noIn
+	p.noIn = true
 	var left = p.expr()
+	p.noIn = noIn
 	if p.match(In) {
-		var right = p.expr()
-		var body []Statement
-		p.eat(LeftBrace)
-		for !p.match(RightBrace) {
-			body = append(body, p.stmt())
+		var name, ok = left.(*Variable)
+		if !ok {
+			panic(fmt.Sprintf("invalid pattern (%d:%d)", left.PosFrom().Line, left.PosFrom().Column))
 		}
-		return &ForStatement{Name: left.(Variable).Name, Expression: right, Body: body, Pos: pos}
-	} else {
-		var body []Statement
-		p.eat(LeftBrace)
-		for !p.match(RightBrace) {
-			body = append(body, p.stmt())
+		var right = p.expr()
+		return &ForStatement{Name: name.Name, Expression: right, Body: p.forBody(), Pos: pos}
+	}
+	return &ForStatement{Name: "it", Expression: left, Body: p.forBody(), Pos: pos}
+}
+
+// forPattern parses the pattern of a for loop, or returns nil if what
+// follows for is not one.
+func (p *Parser) forPattern() (pattern Pattern) {
+	defer func() {
+		if recover() != nil {
+			pattern = nil
 		}
-		return &ForStatement{Name: "it", Expression: left, Body: body, Pos: pos}
+	}()
+	return p.collectionPattern()
+}
+
+func (p *Parser) forBody() []Statement {
+	var body []Statement
+	p.eat(LeftBrace)
+	for !p.match(RightBrace) {
+		body = append(body, p.stmt())
 	}
+	return body
 }
 
 func (p *Parser) doStmt() Statement {
@@ -484,30 +800,90 @@ func (p *Parser) doStmt() Statement {
 
 func (p *Parser) subStmt() Statement {
 	var pos = TokenPos(p.token)
+	var doc = p.token.Doc
 	p.eat(Sub)
 	var name = p.eat(Id).Literal
-	var args []string
+	var params []Param
 	if p.match(LeftParen) {
-		for !p.match(RightParen) {
-			args = append(args, p.eat(Id).Literal)
-			if p.next() != RightParen {
-				p.eat(Comma)
-			}
-		}
+		params = p.params()
 	}
 	var body []Statement
+	var generator, outer = false, p.generator
+	p.generator = &generator
 	p.eat(LeftBrace)
 	for !p.match(RightBrace) {
 		body = append(body, p.stmt())
 	}
-	return &SubStatement{Name: name, Params: args, Body: body, Pos: pos}
+	p.generator = outer
+	return &SubStatement{Name: name, Params: params, Body: body, Doc: doc, Generator: generator, Pos: pos}
+}
+
+// params parses the parameters of a sub after the opening parenthesis:
+// names, each optionally followed by = and a default value, and a last
+// ...rest.
+func (p *Parser) params() []Param {
+	var params []Param
+	var names = map[string]bool{}
+	for !p.match(RightParen) {
+		var rest = p.match(Ellipsis)
+		var param = Param{Name: p.eat(Id).Literal, Rest: rest}
+		if names[param.Name] {
+			panic("duplicate parameter: " + p.prev.String())
+		}
+		names[param.Name] = true
+		if !rest && p.match(Assign) {
+			param.Default = p.expr()
+		}
+		params = append(params, param)
+		if rest {
+			p.eat(RightParen)
+			break
+		}
+		if p.next() != RightParen {
+			p.eat(Comma)
+		}
+	}
+	return params
+}
+
+// classStmt parses `class Name < Parent { sub ... }`. The body holds only
+// the methods; fields are created by assigning to self.
+func (p *Parser) classStmt() Statement {
+	var pos = TokenPos(p.token)
+	var doc = p.token.Doc
+	p.eat(Class)
+	var class = &ClassStatement{Name: p.eat(Id).Literal, Doc: doc, Pos: pos}
+	if p.match(Less) {
+		class.Parent = p.call(false)
+	}
+	p.eat(LeftBrace)
+	for !p.match(RightBrace) {
+		if !p.peek(Sub) {
+			panic("expected sub in class body: " + p.token.String())
+		}
+		class.Methods = append(class.Methods, p.subStmt().(*SubStatement))
+	}
+	return class
 }
 
 func (p *Parser) myStmt() Statement {
 	var pos = TokenPos(p.token)
-	p.eat(My)
+	var const_ = p.match(Const)
+	if !const_ {
+		p.eat(My)
+	}
+	if p.peek(LeftBracket) {
+		var pattern = p.collectionPattern()
+		p.eat(Assign)
+		var value = p.expr()
+		return &MyStatement{Pattern: pattern, Value: &value, Const: const_, Pos: pos}
+	}
 	var name = p.eat(Id).Literal
-	if p.match(Assign) {
+	if const_ {
+		p.eat(Assign)
+		var value = p.expr()
+		return &MyStatement{Name: name, Value: &value, Const: true, Pos: pos}
+	} else if p.match(Assign) {
 		var value = p.expr()
 		return &MyStatement{Name: name, Value: &value, Pos: pos}
 	} else {
@@ -523,47 +899,155 @@ func (p *Parser) whenStmt() Statement {
 		for !p.match(RightBrace) {
 			if p.match(Case) {
 				var condition = p.expr()
-				var body []Statement
 				p.eat(LeftBrace)
-				for !p.match(RightBrace) {
-					body = append(body, p.stmt())
-				}
+				var body = p.branchBody()
 				branches = append(branches, Branch{Condition: condition, Then: body})
 			} else if p.match(Else) {
-				var body []Statement
 				p.eat(LeftBrace)
-				for !p.match(RightBrace) {
-					body = append(body, p.stmt())
-				}
+				var body = p.branchBody()
 				p.eat(RightBrace)
 				return &WhenStatement{Cases: branches, Else_: body, Pos: pos}
 			}
 		}
 		return &WhenStatement{Cases: branches, Else_: nil, Pos: pos}
 	}
-	var condition = p.expr()
+	var value = p.expr()
 	p.eat(LeftBrace)
-	var branches []Branch
+	var branches []MatchBranch
 	for !p.match(RightBrace) {
 		if p.match(Case) {
-			var condition = p.expr()
-			var body []Statement
-			p.eat(LeftBrace)
-			for !p.match(RightBrace) {
-				body = append(body, p.stmt())
+			var branch = MatchBranch{Pattern: p.pattern()}
+			if p.match(If) {
+				branch.Guard = p.expr()
 			}
-			branches = append(branches, Branch{Condition: condition, Then: body})
+			p.eat(LeftBrace)
+			branch.Then = p.branchBody()
+			branches = append(branches, branch)
 		} else if p.match(Else) {
-			var body []Statement
 			p.eat(LeftBrace)
-			for !p.match(RightBrace) {
-				body = append(body, p.stmt())
-			}
+			// an explicit else is never nil, even when empty
+			var body = append([]Statement{}, p.branchBody()...)
 			p.eat(RightBrace)
-			return &WhenMatchStatement{Value: condition, Cases: branches, Else_: body, Pos: pos}
+			return &WhenMatchStatement{Value: value, Cases: branches, Else_: body, Pos: pos}
+		}
+	}
+	return &WhenMatchStatement{Value: value, Cases: branches, Else_: nil, Pos: pos}
+}
+
+// typeNames are the types a type pattern can test for.
+var typeNames = map[string]bool{
+	"str":   true,
+	"bytes": true,
+	"num":   true,
+	"int":   true,
+	"float": true,
+	"bool":  true,
+	"array": true,
+	"hash":  true,
+	"set":   true,
+	"sub":   true,
+}
+
+// patternEnds are the tokens that can follow a complete pattern.
+var patternEnds = map[TokenType]bool{
+	Comma:        true,
+	Colon:        true,
+	RightBracket: true,
+	LeftBrace:    true,
+	If:           true,
+}
+
+// pattern parses a case pattern: a value or a range of values, a type
+// pattern such as `str s`, an array or hash pattern, or a name, which
+// matches anything and binds it. Any other expression, such as `colors.red`
+// or `f(x)`, is compared with the value; putting a name in parentheses, as
+// in `(expected)`, compares with the value of the variable instead of
+// binding it.
+func (p *Parser) pattern() Pattern {
+	var pos = TokenPos(p.token)
+	switch p.next() {
+	case Id:
+		var lexer, token, prev = *p.lexer, p.token, p.prev
+		var name = p.eat(Id).Literal
+		if typeNames[name] {
+			var pattern = &TypePattern{Type: name, Pos: pos}
+			if p.peek(Id) {
+				pattern.Name = p.eat(Id).Literal
+			}
+			return pattern
+		}
+		if patternEnds[p.next()] {
+			return &NamePattern{Name: name, Pos: pos}
 		}
+		*p.lexer, p.token, p.prev = lexer, token, prev
+	case LeftBracket:
+		return p.collectionPattern()
 	}
-	return &WhenMatchStatement{Value: condition, Cases: branches, Else_: nil, Pos: pos}
+	var value = p.addition()
+	if p.match(DotDot) {
+		var exclusive = p.match(Less)
+		return &RangePattern{Low: value, High: p.addition(), Exclusive: exclusive, Pos: pos}
+	}
+	return &LiteralPattern{Value: value, Pos: pos}
+}
+
+// collectionPattern parses an array pattern, whose last element may be
+// ...rest, or a hash pattern, whose keys are bare names or strings.
+func (p *Parser) collectionPattern() Pattern {
+	var pos = TokenPos(p.token)
+	p.eat(LeftBracket)
+	if p.match(Colon) {
+		p.eat(RightBracket)
+		return &HashPattern{Pos: pos}
+	}
+	var array = &ArrayPattern{Pos: pos}
+	var hash *HashPattern
+	for !p.peek(RightBracket) {
+		if hash == nil && p.match(Ellipsis) {
+			array.Rest = &NamePattern{Name: "_", Pos: TokenPos(p.prev)}
+			if p.peek(Id) {
+				array.Rest = &NamePattern{Name: p.eat(Id).Literal, Pos: TokenPos(p.prev)}
+			}
+			break
+		}
+		var element = p.pattern()
+		if hash == nil && len(array.Elements) == 0 && p.peek(Colon) {
+			hash = &HashPattern{Pos: pos}
+		}
+		if hash != nil {
+			p.eat(Colon)
+			hash.Keys = append(hash.Keys, patternKey(element))
+			hash.Values = append(hash.Values, p.pattern())
+		} else {
+			array.Elements = append(array.Elements, element)
+		}
+		if !p.match(Comma) {
+			break
+		}
+	}
+	p.eat(RightBracket)
+	if hash != nil {
+		return hash
+	}
+	return array
+}
+
+// patternKey returns the key of a hash pattern entry, which was parsed as
+// a pattern before the colon showed it to be a key.
+func patternKey(pattern Pattern) string {
+	switch pattern := pattern.(type) {
+	case *NamePattern:
+		return pattern.Name
+	case *TypePattern:
+		if pattern.Name == "" {
+			return pattern.Type
+		}
+	case *LiteralPattern:
+		if key, ok := pattern.Value.(*StringLiteral); ok {
+			return key.Value
+		}
+	}
+	panic(fmt.Sprintf("invalid key in hash pattern (%d:%d)", pattern.PosFrom().Line, pattern.PosFrom().Column))
 }
 
 func (p *Parser) returnStmt() Statement {
@@ -573,5 +1057,32 @@ func (p *Parser) returnStmt() Statement {
 		return &ReturnStatement{Value: nil, Pos: pos}
 	}
 	var value = p.expr()
+	if p.peek(Comma) {
+		var values = &ArrayLiteral{Values: []Expression{value}, Pos: value.PosFrom()}
+		for p.match(Comma) {
+			values.Values = append(values.Values, p.expr())
+		}
+		value = values
+	}
 	return &ReturnStatement{Value: &value, Pos: pos}
 }
+
+// parseNumber converts a Number token to an int, or to a float64 when it has
+// a fraction or exponent or doesn't fit in an int. Prefixed literals must fit
+// in an int.
+func parseNumber(token Token) interface{} {
+	var literal = strings.ReplaceAll(token.Literal, "_", "")
+	if len(literal) > 2 && literal[0] == '0' && strings.ContainsRune("xXbBoO", rune(literal[1])) {
+		var base = map[byte]int{'x': 16, 'b': 2, 'o': 8}[literal[1]|0x20]
+		var value, err = strconv.ParseInt(literal[2:], base, 0)
+		if err != nil {
+			panic(fmt.Sprintf("Number literal %s out of range (%d:%d)", token.Literal, token.Line, token.Column))
+		}
+		return int(value)
+	}
+	if value, err := strconv.Atoi(literal); err == nil {
+		return value
+	}
+	var value, _ = strconv.ParseFloat(literal, 64)
+	return value
+}
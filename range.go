@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Range is the value of a range expression such as 1..10 or 0..<n by 2. It
+// computes its elements on demand, so even a huge range takes no memory.
+// Int is set when the bounds and the step are all ints, and they are then
+// held exactly in IntStart, IntEnd and IntStep, and the elements are ints
+// too. Otherwise they are held as floats in Start, End and Step.
+type Range struct {
+	Start     float64
+	End       float64
+	Step      float64
+	IntStart  int
+	IntEnd    int
+	IntStep   int
+	Exclusive bool
+	Int       bool
+}
+
+// NewRange creates a range from start to end by step, which must be
+// numbers.
+func NewRange(start interface{}, end interface{}, step interface{}, exclusive bool) Range {
+	var r = Range{Exclusive: exclusive, Int: true}
+	for _, bound := range []struct {
+		value interface{}
+		field *float64
+		name  string
+	}{{start, &r.Start, "start"}, {end, &r.End, "end"}, {step, &r.Step, "step"}} {
+		var number, ok = numeric(bound.value)
+		if !ok {
+			panic("Range " + bound.name + " must be a number")
+		}
+		if _, ok := bound.value.(int); !ok {
+			r.Int = false
+		}
+		*bound.field = number
+	}
+	if r.Step == 0 {
+		panic("Range step must not be zero")
+	}
+	if r.Int {
+		r = Range{IntStart: start.(int), IntEnd: end.(int), IntStep: step.(int), Exclusive: exclusive, Int: true}
+	}
+	return r
+}
+
+// Len returns the number of elements in the range. A little slack is
+// allowed for float ranges, so that 0..1 by 0.1 ends at 1.
+func (r Range) Len() int {
+	if r.Int {
+		// the span and step are unsigned, so that they can't overflow
+		var span, step = uint64(r.IntEnd) - uint64(r.IntStart), uint64(r.IntStep)
+		if r.IntStep < 0 {
+			span, step = -span, -step
+		}
+		if r.IntStep > 0 && r.IntEnd < r.IntStart || r.IntStep < 0 && r.IntEnd > r.IntStart {
+			return 0
+		}
+		var n = span / step
+		if !r.Exclusive || span%step != 0 {
+			if n >= math.MaxInt {
+				panic("Range has too many elements: " + r.String())
+			}
+			n++
+		}
+		if n > math.MaxInt {
+			panic("Range has too many elements: " + r.String())
+		}
+		return int(n)
+	}
+	var span = (r.End - r.Start) / r.Step
+	if span < 0 {
+		return 0
+	}
+	var n = math.Floor(span + 1e-9)
+	if r.Exclusive && math.Abs(n-span) < 1e-9 {
+		return int(n)
+	}
+	return int(n) + 1
+}
+
+// At returns the nth element of the range.
+func (r Range) At(n int) interface{} {
+	if r.Int {
+		return r.IntStart + n*r.IntStep
+	}
+	return r.Start + float64(n)*r.Step
+}
+
+// Contains reports whether value is one of the elements of the range: a
+// number between the bounds that is a whole number of steps from the start.
+func (r Range) Contains(value interface{}) bool {
+	if r.Int {
+		var x, ok = value.(int)
+		if f, isFloat := value.(float64); isFloat && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			x, ok = int(f), true
+		}
+		if !ok {
+			return false
+		}
+		if r.IntStep > 0 && (x < r.IntStart || x > r.IntEnd) || r.IntStep < 0 && (x > r.IntStart || x < r.IntEnd) {
+			return false
+		}
+		if r.Exclusive && x == r.IntEnd {
+			return false
+		}
+		var offset = uint64(x) - uint64(r.IntStart)
+		var step = uint64(r.IntStep)
+		if r.IntStep < 0 {
+			offset, step = -offset, -step
+		}
+		return offset%step == 0
+	}
+	var x, ok = numeric(value)
+	if !ok {
+		return false
+	}
+	var steps = (x - r.Start) / r.Step
+	var n = math.Round(steps)
+	return math.Abs(steps-n) < 1e-9 && n >= 0 && int(n) < r.Len()
+}
+
+// Slice returns the elements from index start up to but not including end
+// as a new range.
+func (r Range) Slice(start int, end int) Range {
+	if start < 0 || end > r.Len() || start > end {
+		panic(fmt.Sprintf("Slice bounds out of range: %d:%d", start, end))
+	}
+	if r.Int {
+		return Range{
+			IntStart:  r.IntStart + start*r.IntStep,
+			IntEnd:    r.IntStart + end*r.IntStep,
+			IntStep:   r.IntStep,
+			Exclusive: true,
+			Int:       true,
+		}
+	}
+	return Range{
+		Start:     r.Start + float64(start)*r.Step,
+		End:       r.Start + float64(end)*r.Step,
+		Step:      r.Step,
+		Exclusive: true,
+	}
+}
+
+// Array returns the elements of the range as an array.
+func (r Range) Array() []interface{} {
+	var result = make([]interface{}, r.Len())
+	for n := range result {
+		result[n] = r.At(n)
+	}
+	return result
+}
+
+func (r Range) String() string {
+	var operator = ".."
+	if r.Exclusive {
+		operator = "..<"
+	}
+	if r.Int {
+		var result = fmt.Sprint(r.IntStart) + operator + fmt.Sprint(r.IntEnd)
+		if r.IntStep != 1 {
+			result += " by " + fmt.Sprint(r.IntStep)
+		}
+		return result
+	}
+	var result = fmt.Sprint(r.Start) + operator + fmt.Sprint(r.End)
+	if r.Step != 1 {
+		result += " by " + fmt.Sprint(r.Step)
+	}
+	return result
+}
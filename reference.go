@@ -0,0 +1,364 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Reference is a set of documented entries rendered as one page. Entries are
+// sorted by name, while the fields of an object keep their declared order.
+type Reference struct {
+	Title   string
+	Entries []DocEntry
+}
+
+// StandardReference documents the standard library.
+func StandardReference() Reference {
+	var reference = Reference{Title: "clam standard library"}
+	for _, entry := range docs {
+		reference.Entries = append(reference.Entries, entry)
+	}
+	reference.sort()
+	return reference
+}
+
+// ModuleReference documents the subs and classes of a clam source file,
+// using the ## comments above each one and the ## comment block at the top
+// of the file. Names starting with an underscore are left out.
+func ModuleReference(path string, source string) DocEntry {
+	var lexer = NewLexer(source)
+	var parser = NewParser(lexer)
+	var entry = DocEntry{Name: strings.TrimSuffix(filepath.Base(path), ".clm")}
+	for !parser.peek(Eof) {
+		switch stmt := parser.stmt().(type) {
+		case *SubStatement:
+			if !strings.HasPrefix(stmt.Name, "_") {
+				entry.Fields = append(entry.Fields, subReference(stmt))
+			}
+		case *ClassStatement:
+			if !strings.HasPrefix(stmt.Name, "_") {
+				var class = doc_obj_field(stmt.Name, stmt.Doc)
+				for _, method := range stmt.Methods {
+					if !strings.HasPrefix(method.Name, "_") {
+						class.Fields = append(class.Fields, subReference(method))
+					}
+				}
+				entry.Fields = append(entry.Fields, class)
+			}
+		}
+	}
+	entry.Desc = lexer.Doc
+	return entry
+}
+
+func subReference(sub *SubStatement) DocEntry {
+	var args Args
+	for _, param := range sub.Params {
+		if param.Rest {
+			args.Many = true
+		}
+		args.Args = append(args.Args, param.Name)
+	}
+	return doc_fn_field(sub.Name, args, sub.Doc, "")
+}
+
+func (r *Reference) sort() {
+	sort.Slice(r.Entries, func(a, b int) bool {
+		return r.Entries[a].Name < r.Entries[b].Name
+	})
+}
+
+func (r Reference) functions() []DocEntry {
+	var result []DocEntry
+	for _, entry := range r.Entries {
+		if entry.Args != nil {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+func (r Reference) objects() []DocEntry {
+	var result []DocEntry
+	for _, entry := range r.Entries {
+		if entry.Args == nil {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+var typeNamePattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*`)
+
+// codeSpanPattern matches a `code span` in a description. Its first group is
+// the text inside the backticks.
+var codeSpanPattern = regexp.MustCompile("`([^`]+)`")
+
+// targets maps the names that can refer to an entry to the entry's anchor.
+// An entry can be named by its full dotted path, like geometry.Point.move,
+// or by any shorter tail of it, like Point.move or move, as long as no other
+// entry has the same tail.
+func (r Reference) targets() map[string]string {
+	var targets = map[string]string{}
+	var full = map[string]bool{}
+	var add func(entry DocEntry, parent []string)
+	add = func(entry DocEntry, parent []string) {
+		var path = append(append([]string{}, parent...), entry.Name)
+		full[anchor(path)] = true
+		for k := range path {
+			var name = anchor(path[k:])
+			if old, ok := targets[name]; ok && old != anchor(path) {
+				targets[name] = ""
+			} else {
+				targets[name] = anchor(path)
+			}
+		}
+		for _, field := range entry.Fields {
+			add(field, path)
+		}
+	}
+	for _, entry := range r.Entries {
+		add(entry, nil)
+	}
+	for name := range full {
+		targets[name] = name
+	}
+	return targets
+}
+
+// link replaces every match of pattern in text that names an entry of the
+// reference with the result of link(text, anchor), where text is the
+// match's first group if it has one. The text around the matches goes
+// through plain.
+func (r Reference) link(text string, pattern *regexp.Regexp, link func(text string, anchor string) string, plain func(text string) string) string {
+	var targets = r.targets()
+	var result strings.Builder
+	var last = 0
+	for _, match := range pattern.FindAllStringSubmatchIndex(text, -1) {
+		var name = text[match[0]:match[1]]
+		if pattern.NumSubexp() > 0 && match[2] >= 0 {
+			name = text[match[2]:match[3]]
+		}
+		var target = targets[name]
+		if target == "" {
+			continue
+		}
+		result.WriteString(plain(text[last:match[0]]))
+		result.WriteString(link(name, target))
+		last = match[1]
+	}
+	result.WriteString(plain(text[last:]))
+	return result.String()
+}
+
+func anchor(path []string) string {
+	return strings.Join(path, ".")
+}
+
+// WriteMarkdown renders the reference as a single Markdown document with a
+// table of contents. Every entry gets an explicit anchor, and return types
+// that name another entry link to it.
+func (r Reference) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# " + r.Title + "\n\n")
+	for _, section := range []struct {
+		title   string
+		entries []DocEntry
+	}{{"Functions", r.functions()}, {"Objects", r.objects()}} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		var links []string
+		for _, entry := range section.entries {
+			links = append(links, "[`"+entry.Name+"`](#"+entry.Name+")")
+		}
+		b.WriteString("**" + section.title + ":** " + strings.Join(links, ", ") + "\n\n")
+	}
+	for _, entry := range r.Entries {
+		r.markdownEntry(&b, entry, nil, 2)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (r Reference) markdownEntry(b *strings.Builder, entry DocEntry, parent []string, depth int) {
+	var path = append(append([]string{}, parent...), entry.Name)
+	if depth > 6 {
+		depth = 6
+	}
+	fmt.Fprintf(b, "<a id=\"%s\"></a>\n\n%s %s\n\n", anchor(path), strings.Repeat("#", depth), anchor(path))
+	if entry.Args != nil {
+		b.WriteString("`" + entry.Name + "(" + entry.Args.String() + ")`")
+		if entry.Returns != "" {
+			b.WriteString(" → " + r.link(entry.Returns, typeNamePattern, markdownLink, func(text string) string {
+				if strings.TrimSpace(text) == "" {
+					return text
+				}
+				return "`" + text + "`"
+			}))
+		}
+		b.WriteString("\n\n")
+	}
+	if entry.Desc != "" {
+		b.WriteString(r.link(entry.Desc, codeSpanPattern, markdownLink, func(text string) string {
+			return text
+		}) + "\n\n")
+	}
+	for _, field := range entry.Fields {
+		r.markdownEntry(b, field, path, depth+1)
+	}
+}
+
+func markdownLink(text string, anchor string) string {
+	return "[`" + text + "`](#" + anchor + ")"
+}
+
+func htmlLink(text string, anchor string) string {
+	return "<a href=\"#" + template.HTMLEscapeString(anchor) + "\"><code>" + template.HTMLEscapeString(text) + "</code></a>"
+}
+
+type htmlEntry struct {
+	Anchor    string
+	Heading   string
+	Depth     int
+	Signature template.HTML
+	Desc      template.HTML
+}
+
+type htmlReference struct {
+	Title    string
+	Sections []htmlSection
+	Entries  []htmlEntry
+}
+
+type htmlSection struct {
+	Title string
+	Names []string
+}
+
+// WriteHTML renders the reference as a standalone HTML page with the same
+// structure and links as the Markdown output.
+func (r Reference) WriteHTML(w io.Writer) error {
+	var page = htmlReference{Title: r.Title}
+	for _, section := range []htmlSection{{Title: "Functions"}, {Title: "Objects"}} {
+		var entries = r.functions()
+		if section.Title == "Objects" {
+			entries = r.objects()
+		}
+		for _, entry := range entries {
+			section.Names = append(section.Names, entry.Name)
+		}
+		if len(section.Names) > 0 {
+			page.Sections = append(page.Sections, section)
+		}
+	}
+	var add func(entry DocEntry, parent []string, depth int)
+	add = func(entry DocEntry, parent []string, depth int) {
+		var path = append(append([]string{}, parent...), entry.Name)
+		if depth > 6 {
+			depth = 6
+		}
+		var item = htmlEntry{Anchor: anchor(path), Heading: anchor(path), Depth: depth}
+		item.Desc = template.HTML(r.link(entry.Desc, codeSpanPattern, htmlLink, template.HTMLEscapeString))
+		if entry.Args != nil {
+			var signature = "<code>" + template.HTMLEscapeString(entry.Name+"("+entry.Args.String()+")") + "</code>"
+			if entry.Returns != "" {
+				signature += " → " + r.link(entry.Returns, typeNamePattern, htmlLink, template.HTMLEscapeString)
+			}
+			item.Signature = template.HTML(signature)
+		}
+		page.Entries = append(page.Entries, item)
+		for _, field := range entry.Fields {
+			add(field, path, depth+1)
+		}
+	}
+	for _, entry := range r.Entries {
+		add(entry, nil, 2)
+	}
+	return referenceTemplate.Execute(w, page)
+}
+
+var referenceTemplate = template.Must(template.New("reference").Funcs(template.FuncMap{
+	"heading": func(depth int, anchor string, text string) template.HTML {
+		return template.HTML(fmt.Sprintf("<h%d id=\"%s\">%s</h%d>", depth,
+			template.HTMLEscapeString(anchor), template.HTMLEscapeString(text), depth))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; color: #222; }
+code { background: #f4f4f4; padding: 0 2px; }
+a { color: #0645ad; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Sections}}<p><b>{{.Title}}:</b> {{range $i, $name := .Names}}{{if $i}}, {{end}}<a href="#{{$name}}"><code>{{$name}}</code></a>{{end}}</p>
+{{end}}{{range .Entries}}{{heading .Depth .Anchor .Heading}}
+{{if .Signature}}<p>{{.Signature}}</p>
+{{end}}{{if .Desc}}<p>{{.Desc}}</p>
+{{end}}{{end}}</body>
+</html>
+`))
+
+func docCommand(args []string) {
+	var flags = flag.NewFlagSet("doc", flag.ExitOnError)
+	var format = flags.String("format", "markdown", "output format, markdown or html")
+	var output = flags.String("o", "", "file to write to instead of standard output")
+	_ = flags.Parse(args)
+	var reference = StandardReference()
+	if flags.NArg() > 0 {
+		reference = Reference{Title: "clam modules"}
+		for _, path := range flags.Args() {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "clam doc:", err)
+				os.Exit(1)
+			}
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						fmt.Fprintf(os.Stderr, "%s: %s\n", path, panicMessage(r))
+						os.Exit(1)
+					}
+				}()
+				reference.Entries = append(reference.Entries, ModuleReference(path, string(source)))
+			}()
+		}
+		reference.sort()
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		var file, err = os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "clam doc:", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+	var err error
+	switch *format {
+	case "markdown", "md":
+		err = reference.WriteMarkdown(w)
+	case "html":
+		err = reference.WriteHTML(w)
+	default:
+		fmt.Fprintln(os.Stderr, "clam doc: unknown format "+*format)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "clam doc:", err)
+		os.Exit(1)
+	}
+}
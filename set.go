@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Set is the value of a set literal such as %[1, 2, 3]. Each element is held
+// once, compared the way hash keys are, and the elements keep the order in
+// which they were first added.
+type Set struct {
+	elements *Hash
+}
+
+// NewSet creates a set holding elements.
+func NewSet(elements ...interface{}) *Set {
+	var s = &Set{elements: NewHash()}
+	for _, element := range elements {
+		s.Add(element)
+	}
+	return s
+}
+
+// Len returns the number of elements in s.
+func (s *Set) Len() int {
+	return s.elements.Len()
+}
+
+// Has reports whether element is in s.
+func (s *Set) Has(element interface{}) bool {
+	var _, ok = s.elements.Get(element)
+	return ok
+}
+
+// Add puts element in s, if it isn't there already.
+func (s *Set) Add(element interface{}) {
+	s.elements.Set(element, true)
+}
+
+// At returns the nth element of s.
+func (s *Set) At(n int) interface{} {
+	var element, _ = s.elements.At(n)
+	return element
+}
+
+// Elements returns the elements of s in order.
+func (s *Set) Elements() []interface{} {
+	return s.elements.Keys()
+}
+
+// Union returns the elements of s followed by those of other that are not in
+// s.
+func (s *Set) Union(other *Set) *Set {
+	var result = NewSet(s.Elements()...)
+	for _, element := range other.Elements() {
+		result.Add(element)
+	}
+	return result
+}
+
+// Intersection returns the elements of s that are also in other.
+func (s *Set) Intersection(other *Set) *Set {
+	var result = NewSet()
+	for _, element := range s.Elements() {
+		if other.Has(element) {
+			result.Add(element)
+		}
+	}
+	return result
+}
+
+// Difference returns the elements of s that are not in other.
+func (s *Set) Difference(other *Set) *Set {
+	var result = NewSet()
+	for _, element := range s.Elements() {
+		if !other.Has(element) {
+			result.Add(element)
+		}
+	}
+	return result
+}
+
+func (s *Set) String() string {
+	var elements = make([]string, s.Len())
+	for n := range elements {
+		elements[n] = stringify(s.At(n))
+	}
+	return "%[" + strings.Join(elements, " ") + "]"
+}
+
+// MarshalJSON writes s as a JSON array.
+func (s *Set) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Elements())
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// taskLibrary holds the library functions for tasks and channels, and the
+// ones that do I/O. They need the interpreter they are called in, to let
+// other tasks run while they wait, so prepare binds them to each
+// interpreter.
+var taskLibrary = map[string]func(i *Interpreter) interface{}{}
+
+// spawn starts a task that calls function with args, on a frame of its own
+// that shares only the global scope. It returns the task's handle: a hash
+// whose wait sub waits for the task to finish and returns its result, or
+// raises its error, and whose done and result fields are set when it
+// finishes.
+func (i *Interpreter) spawn(function interface{}, args []interface{}) *Hash {
+	var done = make(chan struct{})
+	var failure interface{}
+	var handle = NewHash()
+	handle.Set("done", false)
+	handle.Set("result", nil)
+	handle.Set("wait", func() interface{} {
+		i.wait(reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+		if failure != nil {
+			panic(failure)
+		}
+		return handle.Value("result")
+	})
+	var frame = &Frame{Variables: []map[string]interface{}{i.Variables[0]}, Pos: i.Pos}
+	i.tasks++
+	i.live.Add(1)
+	go func() {
+		i.lock.Lock()
+		i.Frame = frame
+		defer func() {
+			if r := recover(); r != nil {
+				failure = r
+			}
+			handle.Set("done", true)
+			close(done)
+			i.live.Add(-1)
+			i.checkDeadlock()
+			i.lock.Unlock()
+		}()
+		handle.Set("result", callFunction(function, args))
+	}()
+	return handle
+}
+
+// callback runs f as a task of its own, for library code that calls into
+// the program from a goroutine the program didn't spawn, such as the
+// handlers of an HTTP server. It waits until the interpreter is free.
+func (i *Interpreter) callback(f func()) {
+	i.live.Add(1)
+	i.lock.Lock()
+	i.Frame = &Frame{Variables: []map[string]interface{}{i.Variables[0]}}
+	i.tasks++
+	defer func() {
+		i.live.Add(-1)
+		i.checkDeadlock()
+		i.lock.Unlock()
+	}()
+	f()
+}
+
+// reader returns r with each read letting other tasks run while it waits,
+// for library functions that read from files and connections.
+func (i *Interpreter) reader(r io.Reader) io.Reader {
+	return blockingReader{i, r}
+}
+
+type blockingReader struct {
+	interpreter *Interpreter
+	r           io.Reader
+}
+
+func (r blockingReader) Read(p []byte) (n int, err error) {
+	r.interpreter.block(func() {
+		n, err = r.r.Read(p)
+	})
+	return n, err
+}
+
+// wait lets other tasks run until one of cases can go ahead, like block,
+// for the waits that only another task can end: sending, receiving and
+// waiting for a task. If every task ends up waiting like this, none of them
+// can go on, and each raises a deadlock error instead.
+func (i *Interpreter) wait(cases ...reflect.SelectCase) (chosen int, value reflect.Value, ok bool) {
+	var deadlock = i.deadlock.Load()
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(deadlock.done)})
+	i.block(func() {
+		i.waiting.Add(1)
+		i.checkDeadlock()
+		chosen, value, ok = reflect.Select(cases)
+		if chosen == len(cases)-1 {
+			// the tasks that catch the error can go on waiting, so later
+			// waits need a signal of their own
+			i.deadlock.CompareAndSwap(deadlock, newDeadlockSignal())
+		}
+		i.waiting.Add(-1)
+		i.woken.Add(1)
+	})
+	if chosen == len(cases)-1 {
+		panic("Deadlock: every task is waiting")
+	}
+	return chosen, value, ok
+}
+
+// deadlockSignal is closed to wake the waiting tasks when they deadlock.
+// Each deadlock has a signal of its own.
+type deadlockSignal struct {
+	done chan struct{}
+	once sync.Once
+}
+
+func newDeadlockSignal() *deadlockSignal {
+	return &deadlockSignal{done: make(chan struct{})}
+}
+
+// checkDeadlock signals a deadlock if every live task is waiting. A task
+// that has just been sent a value may not have left wait yet, so it only
+// does so if no wait ends for a while.
+func (i *Interpreter) checkDeadlock() {
+	if i.waiting.Load() < i.live.Load() {
+		return
+	}
+	var deadlock, woken = i.deadlock.Load(), i.woken.Load()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if i.waiting.Load() >= i.live.Load() && i.woken.Load() == woken {
+			deadlock.once.Do(func() {
+				close(deadlock.done)
+			})
+		}
+	}()
+}
+
+// Channel is the value of chan(), which tasks use to pass values to each
+// other. A for loop over a channel receives values until it is closed.
+type Channel struct {
+	values chan interface{}
+	closed bool
+}
+
+func (c *Channel) String() string {
+	return "chan"
+}
+
+// recv receives a value from c, or nil once c is closed and empty. The
+// second result is false in that case.
+func (i *Interpreter) recv(c *Channel) (interface{}, bool) {
+	var _, value, ok = i.wait(reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.values)})
+	if !ok {
+		return nil, false
+	}
+	return value.Interface(), true
+}
+
+func init() {
+	taskLibrary["chan"] = func(i *Interpreter) interface{} {
+		return func(a ...int) *Channel {
+			var size = 0
+			if len(a) > 0 {
+				size = a[0]
+			}
+			return &Channel{values: make(chan interface{}, size)}
+		}
+	}
+	doc_fn("chan", ManyArgs("size"), "Returns a new channel, which holds up to size values that have been sent but not received. Without a size, a send waits for a task to receive the value.", "channel")
+	taskLibrary["send"] = func(i *Interpreter) interface{} {
+		return func(a *Channel, b interface{}) {
+			if a.closed {
+				panic("Send on a closed channel")
+			}
+			i.wait(reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(a.values), Send: reflect.ValueOf(&b).Elem()})
+		}
+	}
+	doc_fn("send", ArgsOf("c", "value"), "Sends value on the channel c, waiting until there is room for it.", "nil")
+	taskLibrary["recv"] = func(i *Interpreter) interface{} {
+		return func(a *Channel) interface{} {
+			var value, _ = i.recv(a)
+			return value
+		}
+	}
+	doc_fn("recv", ArgsOf("c"), "Receives a value from the channel c, waiting until one is sent. Returns nil once c is closed and empty.", "value")
+	taskLibrary["close"] = func(i *Interpreter) interface{} {
+		return func(a *Channel) {
+			if a.closed {
+				panic("Channel is already closed")
+			}
+			a.closed = true
+			close(a.values)
+		}
+	}
+	doc_fn("close", ArgsOf("c"), "Closes the channel c, after which nothing more can be sent on it.", "nil")
+	taskLibrary["select"] = func(i *Interpreter) interface{} {
+		return func(a ...interface{}) interface{} {
+			var cases = make([]reflect.SelectCase, len(a))
+			var timeout = false
+			for j, arg := range a {
+				switch arg := arg.(type) {
+				case *Channel:
+					cases[j] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(arg.values)}
+				case []interface{}:
+					var c *Channel
+					if len(arg) == 2 {
+						c, _ = arg[0].(*Channel)
+					}
+					if c == nil {
+						panic("select takes channels, [channel, value] pairs and a timeout in seconds")
+					}
+					if c.closed {
+						panic("Send on a closed channel")
+					}
+					cases[j] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c.values), Send: reflect.ValueOf(&arg[1]).Elem()}
+				default:
+					var seconds, ok = numeric(arg)
+					if !ok {
+						panic("select takes channels, [channel, value] pairs and a timeout in seconds")
+					}
+					timeout = true
+					var after = time.After(time.Duration(seconds * float64(time.Second)))
+					cases[j] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(after)}
+				}
+			}
+			var chosen int
+			var value reflect.Value
+			var ok bool
+			if timeout {
+				i.block(func() {
+					chosen, value, ok = reflect.Select(cases)
+				})
+			} else {
+				chosen, value, ok = i.wait(cases...)
+			}
+			switch arg := a[chosen].(type) {
+			case *Channel:
+				if !ok {
+					return []interface{}{arg, nil}
+				}
+				return []interface{}{arg, value.Interface()}
+			case []interface{}:
+				return arg
+			}
+			return nil
+		}
+	}
+	doc_fn("select", ManyArgs("cases"), "Waits until one of the cases can go ahead and returns it as [channel, value]. A channel receives a value from it, a [channel, value] pair sends value on the channel, and a number gives up after that many seconds, returning nil.", "array")
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AssertionError is raised by the assert builtins. The test runner reports it
+// as a failure rather than as an error.
+type AssertionError struct {
+	Message string
+}
+
+func (e AssertionError) Error() string {
+	return e.Message
+}
+
+func panicMessage(r interface{}) string {
+	switch r := r.(type) {
+	case string:
+		return r
+	case error:
+		return r.Error()
+	case ReturnValue:
+		return "return outside of a sub"
+	}
+	return fmt.Sprint(r)
+}
+
+// diff returns a line diff between want and got, prefixing lines only in want
+// with "-" and lines only in got with "+".
+func diff(want []string, got []string) []string {
+	var lcs = make([][]int, len(want)+1)
+	for j := range lcs {
+		lcs[j] = make([]int, len(got)+1)
+	}
+	for a := len(want) - 1; a >= 0; a-- {
+		for b := len(got) - 1; b >= 0; b-- {
+			if want[a] == got[b] {
+				lcs[a][b] = lcs[a+1][b+1] + 1
+			} else if lcs[a+1][b] >= lcs[a][b+1] {
+				lcs[a][b] = lcs[a+1][b]
+			} else {
+				lcs[a][b] = lcs[a][b+1]
+			}
+		}
+	}
+	var result []string
+	var a, b = 0, 0
+	for a < len(want) || b < len(got) {
+		switch {
+		case a < len(want) && b < len(got) && want[a] == got[b]:
+			result = append(result, "  "+want[a])
+			a++
+			b++
+		case b == len(got) || a < len(want) && lcs[a+1][b] >= lcs[a][b+1]:
+			result = append(result, "- "+want[a])
+			a++
+		default:
+			result = append(result, "+ "+got[b])
+			b++
+		}
+	}
+	return result
+}
+
+func assertEqualMessage(got interface{}, want interface{}) string {
+	var gotLines = reprLines(got, true)
+	var wantLines = reprLines(want, true)
+	if len(gotLines) == 1 && len(wantLines) == 1 {
+		return "assert_eq: got " + gotLines[0] + ", want " + wantLines[0]
+	}
+	return "assert_eq: values differ (-want +got):\n" + strings.Join(diff(wantLines, gotLines), "\n")
+}
+
+type testResult struct {
+	Name     string
+	File     string
+	Duration time.Duration
+	// Failure is set when an assertion failed, Error when the test raised
+	// any other error or timed out.
+	Failure string
+	Error   string
+}
+
+func (r testResult) passed() bool {
+	return r.Failure == "" && r.Error == ""
+}
+
+type testOptions struct {
+	Run     *regexp.Regexp
+	Timeout time.Duration
+	Verbose bool
+	Cover   bool
+}
+
+// discoverTests returns every *_test.clm file found in paths, descending
+// into directories.
+func discoverTests(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		var info, err = os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), "_test.clm") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// testNames returns the top-level subs of program whose names start with
+// test_, in source order.
+func testNames(program []Statement) []string {
+	var names []string
+	for _, stmt := range program {
+		if sub, ok := stmt.(*SubStatement); ok && strings.HasPrefix(sub.Name, "test_") {
+			names = append(names, sub.Name)
+		}
+	}
+	return names
+}
+
+// runTestFile runs every selected test of the file at path, each in a fresh
+// interpreter. Errors raised while parsing are reported as a failed test.
+func runTestFile(path string, options testOptions) (results []testResult, coverage *Coverage) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return []testResult{{Name: "load", File: path, Error: err.Error()}}, nil
+	}
+	var program []Statement
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				results = []testResult{{Name: "parse", File: path, Error: path + ": " + panicMessage(r)}}
+			}
+		}()
+		program = Parse(string(source))
+	}()
+	if results != nil {
+		return results, nil
+	}
+	printDiagnostics(path, Check(program))
+	if options.Cover {
+		coverage = NewCoverage(path, string(source), program)
+	}
+	for _, name := range testNames(program) {
+		if options.Run != nil && !options.Run.MatchString(name) {
+			continue
+		}
+		if options.Verbose {
+			fmt.Println("=== RUN   " + name)
+		}
+		var result = runTest(path, program, coverage, name, options.Timeout)
+		results = append(results, result)
+		if !result.passed() || options.Verbose {
+			printTestResult(result)
+		}
+	}
+	return results, coverage
+}
+
+// runTest executes the top level of program and then calls the test sub. A
+// test that exceeds timeout is reported and cancelled, which stops it at its
+// next statement; one blocked in a library call, such as a recv that never
+// gets a value, stays blocked until clam exits, but only holds on to its
+// own interpreter.
+func runTest(path string, program []Statement, coverage *Coverage, name string, timeout time.Duration) testResult {
+	var interpreter = prepare(program)
+	interpreter.Coverage = coverage
+	var done = make(chan testResult, 1)
+	var start = time.Now()
+	go func() {
+		var result = testResult{Name: name, File: path}
+		defer func() {
+			if r := recover(); r != nil {
+				var where = fmt.Sprintf("%s:%d:%d: ", path, interpreter.Pos.Line+1, interpreter.Pos.Column+1)
+				if failure, ok := r.(AssertionError); ok {
+					result.Failure = where + failure.Message
+				} else {
+					result.Error = where + panicMessage(r)
+				}
+			}
+			done <- result
+		}()
+		interpreter.Run()
+		callFunction(interpreter.Variables[0][name], nil)
+	}()
+	var result testResult
+	if timeout > 0 {
+		select {
+		case result = <-done:
+		case <-time.After(timeout):
+			interpreter.Cancel()
+			result = testResult{Name: name, File: path, Error: fmt.Sprintf("%s: test timed out after %v", path, timeout)}
+		}
+	} else {
+		result = <-done
+	}
+	result.Duration = time.Since(start)
+	return result
+}
+
+func printTestResult(result testResult) {
+	var status = "PASS"
+	if !result.passed() {
+		status = "FAIL"
+	}
+	fmt.Printf("--- %s: %s (%.2fs)\n", status, result.Name, result.Duration.Seconds())
+	for _, message := range []string{result.Failure, result.Error} {
+		if message == "" {
+			continue
+		}
+		for _, line := range strings.Split(message, "\n") {
+			fmt.Println("    " + line)
+		}
+	}
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+// writeJUnit writes one JUnit testsuite per test file.
+func writeJUnit(path string, files []string, results map[string][]testResult) error {
+	var report junitSuites
+	for _, file := range files {
+		var suite = junitSuite{Name: file}
+		var total time.Duration
+		for _, result := range results[file] {
+			var testCase = junitCase{
+				Name:      result.Name,
+				ClassName: strings.TrimSuffix(filepath.ToSlash(file), ".clm"),
+				Time:      fmt.Sprintf("%.3f", result.Duration.Seconds()),
+			}
+			if result.Failure != "" {
+				suite.Failures++
+				testCase.Failure = &junitFailure{Message: strings.SplitN(result.Failure, "\n", 2)[0], Text: result.Failure}
+			}
+			if result.Error != "" {
+				suite.Errors++
+				testCase.Error = &junitFailure{Message: strings.SplitN(result.Error, "\n", 2)[0], Text: result.Error}
+			}
+			suite.Tests++
+			total += result.Duration
+			suite.Cases = append(suite.Cases, testCase)
+		}
+		suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+		report.Suites = append(report.Suites, suite)
+	}
+	var file, err = os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(xml.Header); err != nil {
+		return err
+	}
+	var encoder = xml.NewEncoder(file)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err = file.WriteString("\n")
+	return err
+}
+
+func testCommand(args []string) {
+	var flags = flag.NewFlagSet("test", flag.ExitOnError)
+	var run = flags.String("run", "", "run only tests whose names match this regular expression")
+	var timeout = flags.Duration("timeout", 10*time.Second, "fail a test that runs longer than this, 0 to disable")
+	var verbose = flags.Bool("v", false, "print every test as it runs")
+	var junit = flags.String("junit", "", "file to write a JUnit XML report to")
+	var cover = flags.Bool("cover", false, "record statement and branch coverage")
+	var coverProfile = flags.String("coverprofile", "coverage.out", "file to write the coverage profile to")
+	var coverHTML = flags.String("coverhtml", "coverage.html", "file to write the annotated coverage view to")
+	_ = flags.Parse(args)
+	var options = testOptions{Timeout: *timeout, Verbose: *verbose, Cover: *cover}
+	if *run != "" {
+		var pattern, err = regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "clam test: invalid -run pattern:", err)
+			os.Exit(2)
+		}
+		options.Run = pattern
+	}
+	var paths = flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var files, err = discoverTests(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "clam test:", err)
+		os.Exit(1)
+	}
+	var failed = false
+	var results = map[string][]testResult{}
+	var coverages []*Coverage
+	for _, file := range files {
+		var start = time.Now()
+		var fileResults, coverage = runTestFile(file, options)
+		results[file] = fileResults
+		if coverage != nil {
+			coverages = append(coverages, coverage)
+		}
+		var status = "ok  "
+		for _, result := range fileResults {
+			if !result.passed() {
+				status = "FAIL"
+				failed = true
+			}
+		}
+		fmt.Printf("%s\t%s\t%.3fs\n", status, file, time.Since(start).Seconds())
+	}
+	if *cover {
+		writeCoverage(coverages, *coverProfile, *coverHTML)
+	}
+	if *junit != "" {
+		if err := writeJUnit(*junit, files, results); err != nil {
+			fmt.Fprintln(os.Stderr, "clam test:", err)
+			os.Exit(1)
+		}
+	}
+	if failed {
+		fmt.Println("FAIL")
+		os.Exit(1)
+	}
+}
@@ -11,56 +11,81 @@ func _() {
 	_ = x[Id-0]
 	_ = x[Number-1]
 	_ = x[String-2]
-	_ = x[True-3]
-	_ = x[False-4]
-	_ = x[Nil-5]
-	_ = x[My-6]
-	_ = x[Sub-7]
-	_ = x[When-8]
-	_ = x[Case-9]
-	_ = x[If-10]
-	_ = x[Unless-11]
-	_ = x[Else-12]
-	_ = x[While-13]
-	_ = x[For-14]
-	_ = x[In-15]
-	_ = x[Until-16]
-	_ = x[Do-17]
-	_ = x[Return-18]
-	_ = x[Inc-19]
-	_ = x[Dec-20]
-	_ = x[By-21]
-	_ = x[Plus-22]
-	_ = x[Minus-23]
-	_ = x[Multiply-24]
-	_ = x[Divide-25]
-	_ = x[Modulo-26]
-	_ = x[And-27]
-	_ = x[Or-28]
-	_ = x[Not-29]
-	_ = x[Equal-30]
-	_ = x[NotEqual-31]
-	_ = x[Less-32]
-	_ = x[LessEqual-33]
-	_ = x[Greater-34]
-	_ = x[GreaterEqual-35]
-	_ = x[Assign-36]
-	_ = x[Comma-37]
-	_ = x[Colon-38]
-	_ = x[Semicolon-39]
-	_ = x[LeftParen-40]
-	_ = x[RightParen-41]
-	_ = x[LeftBrace-42]
-	_ = x[RightBrace-43]
-	_ = x[LeftBracket-44]
-	_ = x[RightBracket-45]
-	_ = x[Dot-46]
-	_ = x[Eof-47]
+	_ = x[Bytes-3]
+	_ = x[True-4]
+	_ = x[False-5]
+	_ = x[Nil-6]
+	_ = x[My-7]
+	_ = x[Const-8]
+	_ = x[Sub-9]
+	_ = x[Class-10]
+	_ = x[When-11]
+	_ = x[Case-12]
+	_ = x[If-13]
+	_ = x[Unless-14]
+	_ = x[Else-15]
+	_ = x[While-16]
+	_ = x[For-17]
+	_ = x[In-18]
+	_ = x[Until-19]
+	_ = x[Do-20]
+	_ = x[Return-21]
+	_ = x[Yield-22]
+	_ = x[Spawn-23]
+	_ = x[Inc-24]
+	_ = x[Dec-25]
+	_ = x[By-26]
+	_ = x[Plus-27]
+	_ = x[Minus-28]
+	_ = x[Multiply-29]
+	_ = x[Divide-30]
+	_ = x[Modulo-31]
+	_ = x[Power-32]
+	_ = x[BitAnd-33]
+	_ = x[BitOr-34]
+	_ = x[BitXor-35]
+	_ = x[ShiftLeft-36]
+	_ = x[ShiftRight-37]
+	_ = x[BitNot-38]
+	_ = x[And-39]
+	_ = x[Or-40]
+	_ = x[Coalesce-41]
+	_ = x[Not-42]
+	_ = x[Equal-43]
+	_ = x[NotEqual-44]
+	_ = x[Less-45]
+	_ = x[LessEqual-46]
+	_ = x[Greater-47]
+	_ = x[GreaterEqual-48]
+	_ = x[NotIn-49]
+	_ = x[Assign-50]
+	_ = x[PlusAssign-51]
+	_ = x[MinusAssign-52]
+	_ = x[MultiplyAssign-53]
+	_ = x[DivideAssign-54]
+	_ = x[ModuloAssign-55]
+	_ = x[AppendAssign-56]
+	_ = x[Comma-57]
+	_ = x[Colon-58]
+	_ = x[Semicolon-59]
+	_ = x[LeftParen-60]
+	_ = x[RightParen-61]
+	_ = x[LeftBrace-62]
+	_ = x[RightBrace-63]
+	_ = x[LeftBracket-64]
+	_ = x[RightBracket-65]
+	_ = x[SetBracket-66]
+	_ = x[Dot-67]
+	_ = x[OptionalDot-68]
+	_ = x[OptionalBracket-69]
+	_ = x[DotDot-70]
+	_ = x[Ellipsis-71]
+	_ = x[Eof-72]
 }
 
-const _TokenType_name = "IdNumberStringTrueFalseNilMySubWhenCaseIfUnlessElseWhileForInUntilDoReturnIncDecByPlusMinusMultiplyDivideModuloAndOrNotEqualNotEqualLessLessEqualGreaterGreaterEqualAssignCommaColonSemicolonLeftParenRightParenLeftBraceRightBraceLeftBracketRightBracketDotEof"
+const _TokenType_name = "IdNumberStringBytesTrueFalseNilMyConstSubClassWhenCaseIfUnlessElseWhileForInUntilDoReturnYieldSpawnIncDecByPlusMinusMultiplyDivideModuloPowerBitAndBitOrBitXorShiftLeftShiftRightBitNotAndOrCoalesceNotEqualNotEqualLessLessEqualGreaterGreaterEqualNotInAssignPlusAssignMinusAssignMultiplyAssignDivideAssignModuloAssignAppendAssignCommaColonSemicolonLeftParenRightParenLeftBraceRightBraceLeftBracketRightBracketSetBracketDotOptionalDotOptionalBracketDotDotEllipsisEof"
 
-var _TokenType_index = [...]uint16{0, 2, 8, 14, 18, 23, 26, 28, 31, 35, 39, 41, 47, 51, 56, 59, 61, 66, 68, 74, 77, 80, 82, 86, 91, 99, 105, 111, 114, 116, 119, 124, 132, 136, 145, 152, 164, 170, 175, 180, 189, 198, 208, 217, 227, 238, 250, 253, 256}
+var _TokenType_index = [...]uint16{0, 2, 8, 14, 19, 23, 28, 31, 33, 38, 41, 46, 50, 54, 56, 62, 66, 71, 74, 76, 81, 83, 89, 94, 99, 102, 105, 107, 111, 116, 124, 130, 136, 141, 147, 152, 158, 167, 177, 183, 186, 188, 196, 199, 204, 212, 216, 225, 232, 244, 249, 255, 265, 276, 290, 302, 314, 326, 331, 336, 345, 354, 364, 373, 383, 394, 406, 416, 419, 430, 445, 451, 459, 462}
 
 func (i TokenType) String() string {
 	if i >= TokenType(len(_TokenType_index)-1) {
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// equal is == for clam values. Numbers are equal if they have the same
+// value, whether they are ints or floats; arrays and hashes are equal if
+// their elements are; and objects with an __eq method decide for
+// themselves.
+func equal(left interface{}, right interface{}) bool {
+	if object, ok := left.(*Object); ok {
+		if result, ok := object.hook("__eq", right); ok {
+			return truthy(result)
+		}
+	}
+	if object, ok := right.(*Object); ok {
+		if result, ok := object.hook("__eq", left); ok {
+			return truthy(result)
+		}
+	}
+	if x, ok := numeric(left); ok {
+		var y, ok = numeric(right)
+		return ok && !math.IsNaN(x) && !math.IsNaN(y) && compareNumbers(left, right) == 0
+	}
+	switch left := left.(type) {
+	case []byte:
+		var right, ok = right.([]byte)
+		return ok && bytes.Equal(left, right)
+	case []interface{}:
+		var right, ok = right.([]interface{})
+		if !ok || len(left) != len(right) {
+			return false
+		}
+		for j := range left {
+			if !equal(left[j], right[j]) {
+				return false
+			}
+		}
+		return true
+	case *Set:
+		var right, ok = right.(*Set)
+		if !ok || left.Len() != right.Len() {
+			return false
+		}
+		for _, element := range left.Elements() {
+			if !right.Has(element) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		var right, ok = right.(*Hash)
+		if !ok || left.Len() != right.Len() {
+			return false
+		}
+		for n := 0; n < left.Len(); n++ {
+			var key, value = left.At(n)
+			if other, ok := right.Get(key); !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	if left == nil || right == nil {
+		return left == right
+	}
+	if reflect.TypeOf(left).Comparable() && reflect.TypeOf(right).Comparable() {
+		return left == right
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// less reports whether left orders before right. If either operand is an
+// object with an __lt method, that decides; otherwise the order is the
+// total order of clam values.
+func less(left interface{}, right interface{}) bool {
+	if object, ok := left.(*Object); ok {
+		if result, ok := object.hook("__lt", right); ok {
+			return truthy(result)
+		}
+	}
+	if object, ok := right.(*Object); ok {
+		if result, ok := object.hook("__lt", left); ok {
+			return !truthy(result) && !equal(left, right)
+		}
+	}
+	return order(left, right) < 0
+}
+
+// rank orders the kinds of value: nil, then booleans, numbers, strings,
+// bytes, arrays, sets, hashes and everything else.
+func rank(value interface{}) int {
+	if _, ok := numeric(value); ok {
+		return 2
+	}
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return 3
+	case []byte:
+		return 4
+	case []interface{}:
+		return 5
+	case *Set:
+		return 6
+	case *Hash:
+		return 7
+	}
+	return 8
+}
+
+// order returns -1, 0 or 1 as left sorts before, with or after right. Values
+// of different kinds sort by rank. Numbers sort by their exact values, with
+// NaN first; strings and bytes sort by bytes; arrays sort element by
+// element; sets sort by their sorted elements; and hashes sort by their
+// sorted keys, then by the values of those keys. Anything else sorts by its
+// type and then its printed form.
+func order(left interface{}, right interface{}) int {
+	var a, b = rank(left), rank(right)
+	if a != b {
+		return sign(a - b)
+	}
+	switch a {
+	case 0:
+		return 0
+	case 1:
+		var x, y = left.(bool), right.(bool)
+		if x == y {
+			return 0
+		} else if y {
+			return -1
+		}
+		return 1
+	case 2:
+		return compareNumbers(left, right)
+	case 3:
+		var x, y = left.(string), right.(string)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case 4:
+		return bytes.Compare(left.([]byte), right.([]byte))
+	case 5:
+		return orderArrays(left.([]interface{}), right.([]interface{}))
+	case 6:
+		var x, y = left.(*Set).Elements(), right.(*Set).Elements()
+		sortValues(x)
+		sortValues(y)
+		return orderArrays(x, y)
+	case 7:
+		var x, y = left.(*Hash), right.(*Hash)
+		var xKeys, yKeys = x.Keys(), y.Keys()
+		sortValues(xKeys)
+		sortValues(yKeys)
+		if result := orderArrays(xKeys, yKeys); result != 0 {
+			return result
+		}
+		for _, key := range xKeys {
+			var a, _ = x.Get(key)
+			var b, _ = y.Get(key)
+			if result := order(a, b); result != 0 {
+				return result
+			}
+		}
+		return 0
+	}
+	if result := order(fmt.Sprintf("%T", left), fmt.Sprintf("%T", right)); result != 0 {
+		return result
+	}
+	return order(fmt.Sprint(left), fmt.Sprint(right))
+}
+
+func orderArrays(left []interface{}, right []interface{}) int {
+	for j := 0; j < len(left) && j < len(right); j++ {
+		if result := order(left[j], right[j]); result != 0 {
+			return result
+		}
+	}
+	return sign(len(left) - len(right))
+}
+
+func compareInts(x int, y int) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// compareNumbers returns -1, 0 or 1 as the number left is less than, equal
+// to or greater than right, with NaN first. Whole numbers are compared as
+// ints, as hashKey keys them, and an int and a float that is not whole are
+// compared exactly as big.Floats, so that no precision is lost.
+func compareNumbers(left interface{}, right interface{}) int {
+	var x, y = hashKey(left), hashKey(right)
+	if x, ok := x.(int); ok {
+		if y, ok := y.(int); ok {
+			return compareInts(x, y)
+		}
+	}
+	var a, _ = numeric(x)
+	var b, _ = numeric(y)
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return sign(nanRank(b) - nanRank(a))
+	}
+	if x, ok := x.(int); ok {
+		return new(big.Float).SetInt64(int64(x)).Cmp(big.NewFloat(b))
+	}
+	if y, ok := y.(int); ok {
+		return big.NewFloat(a).Cmp(new(big.Float).SetInt64(int64(y)))
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func nanRank(x float64) int {
+	if math.IsNaN(x) {
+		return 0
+	}
+	return 1
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// sortValues sorts values in order.
+func sortValues(values []interface{}) {
+	sort.Slice(values, func(a, b int) bool {
+		return order(values[a], values[b]) < 0
+	})
+}
+
+// contains reports whether element is in collection, for the in operator:
+// an element of an array, set or range, a key of a hash, or a substring of
+// a string or bytes.
+func contains(collection interface{}, element interface{}) bool {
+	switch collection := collection.(type) {
+	case []interface{}:
+		for _, value := range collection {
+			if equal(value, element) {
+				return true
+			}
+		}
+		return false
+	case *Set:
+		return collection.Has(element)
+	case *Hash:
+		var _, ok = collection.Get(element)
+		return ok
+	case Range:
+		return collection.Contains(element)
+	case string:
+		if element, ok := element.(string); ok {
+			return strings.Contains(collection, element)
+		}
+		panic("Left operand of in must be a string when the right is a string")
+	case []byte:
+		if b, ok := integer(element); ok {
+			return b >= 0 && b <= 255 && bytes.IndexByte(collection, byte(b)) >= 0
+		}
+		if element, ok := toBytes(element); ok {
+			return bytes.Contains(collection, element)
+		}
+		panic("Left operand of in must be a byte, bytes or a string when the right is bytes")
+	}
+	panic("Right operand of in must be an array, set, hash, range, string or bytes")
+}
+
+// hasKey reports whether collection has an element at key: a key of a hash,
+// or an index of an array, string, bytes or range.
+func hasKey(collection interface{}, key interface{}) bool {
+	var length int
+	switch collection := collection.(type) {
+	case *Hash:
+		var _, ok = collection.Get(key)
+		return ok
+	case []interface{}:
+		length = len(collection)
+	case string:
+		length = utf8.RuneCountInString(collection)
+	case []byte:
+		length = len(collection)
+	case Range:
+		length = collection.Len()
+	default:
+		return false
+	}
+	var index, ok = integer(key)
+	return ok && index >= 0 && index < length
+}
+
+func numeric(a interface{}) (float64, bool) {
+	switch a := a.(type) {
+	case int:
+		return float64(a), true
+	case int8:
+		return float64(a), true
+	case int16:
+		return float64(a), true
+	case int32:
+		return float64(a), true
+	case int64:
+		return float64(a), true
+	case uint8:
+		return float64(a), true
+	case uint16:
+		return float64(a), true
+	case uint32:
+		return float64(a), true
+	case uint64:
+		return float64(a), true
+	case float32:
+		return float64(a), true
+	case float64:
+		return a, true
+	}
+	return 0, false
+}
+
+// repr formats a value the way it would be written in clam source.
+func repr(value interface{}) string {
+	return reprLines(value, false)[0]
+}
+
+// reprLines formats a value as lines of clam source. When multiline is set,
+// arrays and hashes put each element on its own indented line.
+func reprLines(value interface{}, multiline bool) []string {
+	var elements []string
+	var empty string
+	var open = "["
+	switch value := value.(type) {
+	case nil:
+		return []string{"nil"}
+	case string:
+		return []string{strconv.Quote(value)}
+	case []byte:
+		return []string{"b" + strconv.Quote(string(value))}
+	case []interface{}:
+		empty = "[]"
+		for _, element := range value {
+			var lines = reprLines(element, multiline)
+			if multiline {
+				lines[len(lines)-1] += ","
+				elements = append(elements, lines...)
+			} else {
+				elements = append(elements, lines[0])
+			}
+		}
+	case *Set:
+		empty = "%[]"
+		open = "%["
+		for _, element := range value.Elements() {
+			var lines = reprLines(element, multiline)
+			if multiline {
+				lines[len(lines)-1] += ","
+				elements = append(elements, lines...)
+			} else {
+				elements = append(elements, lines[0])
+			}
+		}
+	case *Hash:
+		empty = "[:]"
+		for n := 0; n < value.Len(); n++ {
+			var key, element = value.At(n)
+			var lines = reprLines(element, multiline)
+			lines[0] = repr(key) + ": " + lines[0]
+			if multiline {
+				lines[len(lines)-1] += ","
+				elements = append(elements, lines...)
+			} else {
+				elements = append(elements, lines[0])
+			}
+		}
+	default:
+		return []string{fmt.Sprint(value)}
+	}
+	if len(elements) == 0 {
+		return []string{empty}
+	}
+	if !multiline {
+		return []string{open + strings.Join(elements, ", ") + "]"}
+	}
+	var lines = []string{open}
+	for _, element := range elements {
+		lines = append(lines, "    "+element)
+	}
+	return append(lines, "]")
+}